@@ -0,0 +1,276 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/macroblock/rtimg/pkg"
+)
+
+func isArchive(filePath string) bool {
+	return strings.ToLower(filepath.Ext(filePath)) == ".zip"
+}
+
+// archiveEntryPath returns a virtual path of the archive entry: the archive name
+// without the extension is treated as a directory.
+func archiveEntryPath(archivePath, name string) string {
+	dir := strings.TrimSuffix(archivePath, filepath.Ext(archivePath))
+	return filepath.Join(dir, filepath.FromSlash(name))
+}
+
+// tArchive - an opened zip archive. It is the file system of its entries with the
+// reduced ones replaced, so the commands and the report see the reduced data. The
+// archive itself is not changed: reduced and renamed entries go to its copies.
+type tArchive struct {
+	// the path as it was given
+	path string
+	// the absolute path of the archive as a directory (see archiveEntryPath)
+	dir      string
+	reader   *zip.ReadCloser
+	replaced map[string][]byte
+	// renames of the entries (slash separated names)
+	renames []rtimg.TRename
+}
+
+var archives []*tArchive // Guarded by mtx.
+
+// Open implements fs.FS.
+func (o *tArchive) Open(name string) (fs.File, error) {
+	data, ok := o.replaced[name]
+	if !ok {
+		return o.reader.Open(name)
+	}
+	info, err := fs.Stat(o.reader, name)
+	if err != nil {
+		return nil, err
+	}
+	return &tEntryFile{Reader: bytes.NewReader(data), info: tEntryInfo{info, int64(len(data))}}, nil
+}
+
+// tEntryFile - a replaced entry of an archive.
+type tEntryFile struct {
+	*bytes.Reader
+	info fs.FileInfo
+}
+
+func (o *tEntryFile) Stat() (fs.FileInfo, error) {
+	return o.info, nil
+}
+
+func (o *tEntryFile) Close() error {
+	return nil
+}
+
+// tEntryInfo - the info of the original entry with the size of the replacement.
+type tEntryInfo struct {
+	fs.FileInfo
+	size int64
+}
+
+func (o tEntryInfo) Size() int64 {
+	return o.size
+}
+
+// copyPath returns the path of a copy of the archive next to it.
+func (o *tArchive) copyPath(suffix string) string {
+	ext := filepath.Ext(o.path)
+	return strings.TrimSuffix(o.path, ext) + suffix + ext
+}
+
+// archiveProcess processes entries of the zip archive as files of a directory
+// (ignore files and -include/-exclude apply too). If some of the entries were
+// reduced, a corrected copy of the archive is written next to it with the "_reduced"
+// suffix. The archive stays open for the commands that run after all the files
+// (see closeArchives).
+func archiveProcess(archivePath string) {
+	absPath, err := filepath.Abs(archivePath)
+	if err != nil {
+		setError(archivePath, err)
+		return
+	}
+	r, err := zip.OpenReader(archivePath)
+	if err != nil {
+		setError(archivePath, err)
+		return
+	}
+	archive := &tArchive{
+		path:     archivePath,
+		dir:      archiveEntryPath(absPath, "."),
+		reader:   r,
+		replaced: map[string][]byte{},
+	}
+	mtx.Lock()
+	archives = append(archives, archive)
+	mtx.Unlock()
+
+	w := &tWalker{
+		fsys: r,
+		join: func(name string) string {
+			return archiveEntryPath(archivePath, name)
+		},
+	}
+	names, err := w.walk(".")
+	if err != nil {
+		setError(archivePath, err)
+		return
+	}
+	for _, name := range names {
+		processFile(tFile{
+			fsys:       archive,
+			name:       name,
+			path:       archiveEntryPath(absPath, name),
+			reportPath: w.join(name),
+			archive:    archive,
+		})
+	}
+
+	if len(archive.replaced) == 0 {
+		return
+	}
+	outPath := archive.copyPath("_reduced")
+	err = writeZip(outPath, r.File, archive.replaced, nil)
+	if err != nil {
+		setError(outPath, err)
+		return
+	}
+	printGreen(filepath.Base(outPath), fmt.Sprintf("%v file(s) replaced", len(archive.replaced)))
+}
+
+// closeArchives closes the archives opened by archiveProcess.
+func closeArchives() {
+	for _, v := range archives {
+		v.reader.Close()
+	}
+	archives = nil
+}
+
+// reduce reduces a copy of the entry, the entry is replaced by it if it has changed.
+func (o *tArchive) reduce(name string, sizeLimit int64, data *rtimg.TKeyData) (*rtimg.TReduceResult, error) {
+	content, err := fs.ReadFile(o, name)
+	if err != nil {
+		return nil, err
+	}
+	size := int64(len(content))
+	tmp, err := ioutil.TempFile("", "rtimg-*"+path.Ext(name))
+	if err != nil {
+		return nil, err
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
+	_, err = tmp.Write(content)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	reduced, err := rtimg.ReduceImage(tmpPath, sizeLimit, data)
+	if err != nil {
+		return nil, err
+	}
+	content, err = ioutil.ReadFile(tmpPath)
+	if err != nil {
+		return nil, err
+	}
+	if reduced.Size != size {
+		o.replaced[name] = content
+	}
+	return reduced, nil
+}
+
+// addRename plans renaming of the entry to the (virtual) target path.
+func (o *tArchive) addRename(name, target string) error {
+	rel, err := filepath.Rel(o.dir, target)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("rename: %v is outside of the archive", target)
+	}
+	o.renames = append(o.renames, rtimg.TRename{From: name, To: filepath.ToSlash(rel)})
+	return nil
+}
+
+// renameEntries prints planned renames of the entries and, if the apply flag is
+// set, writes a copy of the archive with the entries renamed next to it with the
+// "_renamed" suffix. It returns the number of the renames.
+func (o *tArchive) renameEntries() int {
+	list, errs := rtimg.PlanRenamesFS(o.reader, o.renames)
+	for _, err := range errs {
+		errorsArray = append(errorsArray, "\x1b[31;1mrename: "+o.path+": "+err.Error()+"\x1b[0m")
+	}
+	if len(list) == 0 {
+		return 0
+	}
+	status := "\x1b[33;1mpreview\x1b[0m"
+	if flagApply {
+		status = "\x1b[32;1mdone\x1b[0m"
+		renamed := map[string]string{}
+		for _, v := range list {
+			renamed[v.From] = v.To
+		}
+		outPath := o.copyPath("_renamed")
+		if err := writeZip(outPath, o.reader.File, nil, renamed); err != nil {
+			status = "\x1b[31;1m" + err.Error() + "\x1b[0m"
+			appendError(outPath, err)
+		}
+	}
+	for _, v := range list {
+		printRename(status, archiveEntryPath(o.path, v.From), archiveEntryPath(o.path, v.To))
+	}
+	return len(list)
+}
+
+func readZipFile(f *zip.File) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return ioutil.ReadAll(rc)
+}
+
+// writeZip writes a copy of the archive files with some of them replaced and renamed.
+func writeZip(outPath string, files []*zip.File, replaced map[string][]byte, renamed map[string]string) error {
+	out, err := os.Create(outPath)
+	if err != nil {
+		return err
+	}
+	w := zip.NewWriter(out)
+	err = func() error {
+		for _, f := range files {
+			header := f.FileHeader
+			if name, ok := renamed[f.Name]; ok {
+				header.Name = name
+			}
+			data, ok := replaced[f.Name]
+			if !ok && !f.FileInfo().IsDir() {
+				var err error
+				data, err = readZipFile(f)
+				if err != nil {
+					return err
+				}
+			}
+			fw, err := w.CreateHeader(&header)
+			if err != nil {
+				return err
+			}
+			if _, err := fw.Write(data); err != nil {
+				return err
+			}
+		}
+		return w.Close()
+	}()
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(outPath)
+	}
+	return err
+}
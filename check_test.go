@@ -3,6 +3,7 @@ package main
 import (
 	"strings"
 	"testing"
+	"testing/fstest"
 
 	"github.com/macroblock/imed/pkg/tagname"
 	rtimg "github.com/macroblock/rtimg/pkg"
@@ -40,13 +41,13 @@ func TestCorrect(t *testing.T) {
 			continue
 		}
 
-		data, err := rtimg.CheckImage("", tn)
+		res, err := rtimg.CheckImage("", tn)
 		if err != nil {
 			t.Errorf("\n%q\nCheckImage() error:\n%v", v.input, err)
 			continue
 		}
 
-		if data.FileSizeLimit != v.limit {
+		if res.Limit != v.limit {
 			t.Errorf("\n%q\nCheckImage() error:\n%v", v.input, err)
 			continue
 		}
@@ -61,8 +62,8 @@ func TestIncorrect(t *testing.T) {
 			t.Errorf("\n%q\nNewFromFilename() error:\n%v", v, err)
 			continue
 		}
-		sizeLimit, err := rtimg.CheckImage("", tn)
-		_ = sizeLimit
+		res, err := rtimg.CheckImage("", tn)
+		_ = res
 		if err == nil {
 			t.Errorf("\n%q\nhas no error", v)
 			continue
@@ -152,3 +153,115 @@ func TestKey(t *testing.T) {
 	projectLeaf = "/1 сезон/600x600.jpg"
 	testEntry(t, projectDir, projectLeaf)
 }
+
+// TestSplitFileList -
+func TestSplitFileList(t *testing.T) {
+	table := []struct {
+		input string
+		want  []string
+	}{
+		{"a.jpg\nb/c.png\n", []string{"a.jpg", "b/c.png"}},
+		{"a.jpg\r\n\r\nb c.png", []string{"a.jpg", "b c.png"}},
+		{"a.jpg\x00b\nc.png\x00", []string{"a.jpg", "b\nc.png"}},
+		{"", []string{}},
+	}
+	for _, v := range table {
+		got := splitFileList([]byte(v.input))
+		if strings.Join(got, "|") != strings.Join(v.want, "|") || len(got) != len(v.want) {
+			t.Errorf("\n%q\nsplitFileList() = %q, want %q", v.input, got, v.want)
+		}
+	}
+}
+
+// TestMatchGlobs -
+func TestMatchGlobs(t *testing.T) {
+	table := []struct {
+		patterns []string
+		rel      string
+		want     bool
+	}{
+		{[]string{"*.bak"}, "a/b/c.jpg.bak", true},
+		{[]string{".DS_Store"}, "a/.DS_Store", true},
+		{[]string{"*.jpg"}, "a/b/c.png", false},
+		{[]string{"src/*"}, "src/footage.mov", true},
+		{[]string{"src/*"}, "a/src/footage.mov", false},
+		{nil, "a.jpg", false},
+	}
+	for _, v := range table {
+		if got := matchGlobs(v.patterns, v.rel); got != v.want {
+			t.Errorf("matchGlobs(%q, %q) = %v, want %v", v.patterns, v.rel, got, v.want)
+		}
+	}
+}
+
+// TestPreviewName -
+func TestPreviewName(t *testing.T) {
+	table := []struct {
+		path string
+		want string
+	}{
+		{"a/PROJECT/1920x1080.jpg", "a_PROJECT_1920x1080.jpg.png"},
+		{"b/PROJECT/1920x1080.jpg", "b_PROJECT_1920x1080.jpg.png"},
+		{"/x/PROJECT/1 сезон/600x840.jpg", "x_PROJECT_1 сезон_600x840.jpg.png"},
+		{"PROJECT/для сервиса/1920x1080.jpg", "PROJECT_для сервиса_1920x1080.jpg.png"},
+	}
+	for _, v := range table {
+		key, err := rtimg.FindKey(v.path, nil)
+		if err != nil {
+			t.Errorf("FindKey(%q) error: %v", v.path, err)
+			continue
+		}
+		if got := previewName(key); got != v.want {
+			t.Errorf("previewName(%q) = %q, want %q", v.path, got, v.want)
+		}
+	}
+}
+
+// TestWalk -
+func TestWalk(t *testing.T) {
+	defer func(exclude, include stringList, skip bool) {
+		flagExclude, flagInclude, flagSkipUnknownExt = exclude, include, skip
+	}(flagExclude, flagInclude, flagSkipUnknownExt)
+
+	fsys := fstest.MapFS{
+		"root/PROJECT/600x600.jpg":          {},
+		"root/PROJECT/600x600.psd":          {},
+		"root/PROJECT/notes.txt":            {},
+		"root/PROJECT/.rtimgignore":         {Data: []byte("# sources\nsrc/*\n*.bak\n")},
+		"root/PROJECT/src/footage.mov":      {},
+		"root/PROJECT/1 сезон/350x500.jpg":  {},
+		"root/PROJECT/1 сезон/350x500.bak":  {},
+		"root/OTHER/1920x1080.jpg":          {},
+		"root/OTHER/tmp/1920x1080_left.jpg": {},
+		"root/OTHER/archive.zip":            {},
+	}
+	table := []struct {
+		exclude, include stringList
+		skip             bool
+		want             []string
+	}{
+		{nil, nil, false, []string{
+			"root/OTHER/1920x1080.jpg", "root/OTHER/archive.zip", "root/OTHER/tmp/1920x1080_left.jpg",
+			"root/PROJECT/1 сезон/350x500.jpg", "root/PROJECT/600x600.jpg", "root/PROJECT/600x600.psd", "root/PROJECT/notes.txt",
+		}},
+		{stringList{"tmp", "*.psd"}, nil, true, []string{
+			"root/OTHER/1920x1080.jpg", "root/OTHER/archive.zip",
+			"root/PROJECT/1 сезон/350x500.jpg", "root/PROJECT/600x600.jpg",
+		}},
+		{nil, stringList{"*.jpg"}, false, []string{
+			"root/OTHER/1920x1080.jpg", "root/OTHER/tmp/1920x1080_left.jpg",
+			"root/PROJECT/1 сезон/350x500.jpg", "root/PROJECT/600x600.jpg",
+		}},
+	}
+	for _, v := range table {
+		flagExclude, flagInclude, flagSkipUnknownExt = v.exclude, v.include, v.skip
+		w := &tWalker{fsys: fsys, join: func(p string) string { return p }}
+		got, err := w.walk("root")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(got, "|") != strings.Join(v.want, "|") {
+			t.Errorf("walk(%q, %q) = %q, want %q", v.exclude, v.include, got, v.want)
+		}
+	}
+}
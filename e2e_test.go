@@ -0,0 +1,406 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	rtimg "github.com/macroblock/rtimg/pkg"
+)
+
+// tFakeTools - ffmpeg, pngquant, jpegtran and exiftool that re-encode images with the
+// standard library.
+type tFakeTools struct {
+	mtx   sync.Mutex
+	calls map[string]int
+}
+
+func (o *tFakeTools) Run(dir, name string, args ...string) ([]byte, error) {
+	o.mtx.Lock()
+	o.calls[name]++
+	o.mtx.Unlock()
+
+	arg := func(flag string) string {
+		for i := 0; i < len(args)-1; i++ {
+			if args[i] == flag {
+				return args[i+1]
+			}
+		}
+		return ""
+	}
+	switch name {
+	default:
+		return nil, &exec.Error{Name: name, Err: exec.ErrNotFound}
+	case "exiftool":
+		return nil, nil
+	case "jpegtran":
+		data, err := ioutil.ReadFile(args[len(args)-1])
+		if err != nil {
+			return nil, err
+		}
+		return nil, ioutil.WriteFile(arg("-outfile"), data, 0644)
+	case "ffmpeg":
+		img, err := decodeFile(arg("-i"))
+		if err != nil {
+			return []byte(err.Error()), nil
+		}
+		q, _ := strconv.Atoi(arg("-q:v"))
+		return nil, encodeFile(args[len(args)-1], img, 100-q*3)
+	case "pngquant":
+		img, err := decodeFile(args[len(args)-1])
+		if err != nil {
+			return []byte(err.Error()), nil
+		}
+		quantized := image.NewPaletted(img.Bounds(), palette.Plan9)
+		draw.Draw(quantized, img.Bounds(), img, image.Point{}, draw.Src)
+		return nil, encodeFile(arg("--output"), quantized, 0)
+	}
+}
+
+func decodeFile(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	return img, err
+}
+
+// encodeFile writes the image in the format of the extension (psd gets only a signature).
+func encodeFile(path string, img image.Image, quality int) error {
+	buf := &bytes.Buffer{}
+	var err error
+	switch rtimg.NormalizeExt(filepath.Ext(path)) {
+	case ".jpg":
+		err = jpeg.Encode(buf, img, &jpeg.Options{Quality: quality})
+	case ".png":
+		err = png.Encode(buf, img)
+	case ".psd":
+		buf.WriteString("8BPS")
+	}
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, buf.Bytes(), 0644)
+}
+
+func noise(w, h int, seed int64) image.Image {
+	r := rand.New(rand.NewSource(seed))
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(r.Intn(256))
+		if i%4 == 3 {
+			img.Pix[i] = 255
+		}
+	}
+	return img
+}
+
+func uniform(w, h int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.Black), image.Point{}, draw.Src)
+	return img
+}
+
+// writeTree creates files of the tree. Images are written by extension, nil ones are empty.
+func writeTree(t *testing.T, root string, tree map[string]image.Image) {
+	t.Helper()
+	for name, img := range tree {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if img == nil {
+			if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err := encodeFile(path, img, 100); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// resetState clears results of a previous run.
+func resetState() {
+	errorsArray = nil
+	warningsArray = nil
+	reportEntries = nil
+	dupeEntries = nil
+	renames = nil
+	archives = nil
+	rootDirMap = map[string]RootDirData{}
+	count = 0
+}
+
+// TestEndToEnd -
+func TestEndToEnd(t *testing.T) {
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// directories are renamed relative to the working directory
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	tools := &tFakeTools{calls: map[string]int{}}
+	defer func(runner rtimg.ICommandRunner) {
+		rtimg.CommandRunner = runner
+	}(rtimg.CommandRunner)
+	rtimg.CommandRunner = tools
+
+	defer func(recursive, reduce bool, re *regexp.Regexp, html string, n int) {
+		flagRecursive, flagDoReduceSize, nameFileRe, flagHTMLReport, threads = recursive, reduce, re, html, n
+		resetState()
+	}(flagRecursive, flagDoReduceSize, nameFileRe, flagHTMLReport, threads)
+	resetState()
+	flagRecursive = true
+	flagDoReduceSize = true
+	nameFileRe = regexp.MustCompile(`^#(.+)#$`)
+	flagHTMLReport = filepath.Join(dir, "report.html")
+	threads = 2
+
+	truncated := &bytes.Buffer{}
+	if err := jpeg.Encode(truncated, noise(60, 60, 3), nil); err != nil {
+		t.Fatal(err)
+	}
+	writeTree(t, "root", map[string]image.Image{
+		"GOOD/600x600.jpg":        noise(600, 600, 1),
+		"GOOD/600x600.psd":        noise(1, 1, 0),
+		"GOOD/logo.png":           noise(600, 600, 2),
+		"GOOD/#GOOD_renamed#":     nil,
+		"WARN/600x840.jpg":        uniform(60, 84),
+		"WARN/#WARN_renamed#":     nil,
+		"BAD/600x600.jpg":         nil,
+		"BAD/#BAD_renamed#":       nil,
+		"BAD/1 сезон/810x489.jpg": noise(81, 49, 4),
+	})
+	if err := ioutil.WriteFile("root/BAD/600x600.jpg", truncated.Bytes()[:truncated.Len()/2], 0644); err != nil {
+		t.Fatal(err)
+	}
+	length = 7
+
+	process([]string{"root"})
+
+	// files
+	for name, limit := range map[string]int64{"GOOD_renamed/600x600.jpg": 700 * 1000, "GOOD_renamed/logo.png": 900 * 1000} {
+		info, err := os.Stat(name)
+		if err != nil {
+			t.Errorf("%v: %v", name, err)
+			continue
+		}
+		if info.Size() > limit {
+			t.Errorf("%v: %v bytes, limit %v", name, info.Size(), limit)
+		}
+		if _, err := decodeFile(name); err != nil {
+			t.Errorf("%v: %v", name, err)
+		}
+	}
+	if tools.calls["exiftool"] == 0 || tools.calls["ffmpeg"] == 0 || tools.calls["pngquant"] == 0 {
+		t.Errorf("fake tools were not called: %v", tools.calls)
+	}
+
+	// renamed directories: warnings do not block the rename, errors do
+	for _, name := range []string{"GOOD_renamed", "WARN_renamed", "root/BAD"} {
+		if _, err := os.Stat(name); err != nil {
+			t.Errorf("%v: %v", name, err)
+		}
+	}
+	for _, name := range []string{"root/GOOD", "root/WARN", "BAD_renamed"} {
+		if _, err := os.Stat(name); err == nil {
+			t.Errorf("%v must not exist", name)
+		}
+	}
+
+	// reports
+	if len(warningsArray) != 1 || !strings.Contains(warningsArray[0], "near-uniform") {
+		t.Errorf("warnings: %q", warningsArray)
+	}
+	errs := strings.Join(errorsArray, "\n")
+	for _, s := range []string{"did you mean ./810x498.jpg", "root/BAD: was errors"} {
+		if !strings.Contains(errs, s) {
+			t.Errorf("errors %q do not contain %q", errs, s)
+		}
+	}
+	if strings.Contains(errs, "GOOD") || strings.Contains(errs, "WARN") {
+		t.Errorf("errors: %q", errs)
+	}
+
+	statuses := map[string]string{}
+	for _, v := range reportEntries {
+		statuses[filepath.ToSlash(v.Path)] = v.Status
+	}
+	for path, status := range map[string]string{
+		"root/GOOD/600x600.jpg":        rtimg.StatusReduced,
+		"root/GOOD/600x600.psd":        rtimg.StatusOk,
+		"root/GOOD/logo.png":           rtimg.StatusReduced,
+		"root/WARN/600x840.jpg":        rtimg.StatusWarning,
+		"root/BAD/600x600.jpg":         rtimg.StatusError,
+		"root/BAD/1 сезон/810x489.jpg": rtimg.StatusError,
+	} {
+		if statuses[path] != status {
+			t.Errorf("%v: status %q, want %q", path, statuses[path], status)
+		}
+	}
+	html, err := ioutil.ReadFile(flagHTMLReport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(html, []byte("did you mean ./810x498.jpg")) || !bytes.Contains(html, []byte("near-uniform")) ||
+		!bytes.Contains(html, []byte("lossy: q:")) {
+		t.Errorf("html report is incomplete")
+	}
+}
+
+// writeZipTree writes an archive of the tree (see writeTree).
+func writeZipTree(t *testing.T, zipPath string, tree map[string]image.Image) {
+	t.Helper()
+	dir := t.TempDir()
+	writeTree(t, dir, tree)
+	out, err := os.Create(zipPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	w := zip.NewWriter(out)
+	for name := range tree {
+		data, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fw.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readZipTree returns entries of the archive by names.
+func readZipTree(t *testing.T, zipPath string) map[string][]byte {
+	t.Helper()
+	r, err := zip.OpenReader(zipPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	ret := map[string][]byte{}
+	for _, f := range r.File {
+		data, err := readZipFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ret[f.Name] = data
+	}
+	return ret
+}
+
+// TestArchive -
+func TestArchive(t *testing.T) {
+	dir := t.TempDir()
+	tools := &tFakeTools{calls: map[string]int{}}
+	defer func(runner rtimg.ICommandRunner) {
+		rtimg.CommandRunner = runner
+	}(rtimg.CommandRunner)
+	rtimg.CommandRunner = tools
+
+	defer func(reduce, apply bool, html, cmd, layout string, n int) {
+		flagDoReduceSize, flagApply, flagHTMLReport, command, flagLayout, threads = reduce, apply, html, cmd, layout, n
+		resetState()
+	}(flagDoReduceSize, flagApply, flagHTMLReport, command, flagLayout, threads)
+	resetState()
+	flagDoReduceSize = true
+	flagHTMLReport = filepath.Join(dir, "report.html")
+	threads = 1
+
+	// check and reduce
+	zipPath := filepath.Join(dir, "posters.zip")
+	writeZipTree(t, zipPath, map[string]image.Image{
+		"PROJECT/600x600.jpg": noise(600, 600, 1),
+		"PROJECT/600x840.jpg": noise(60, 84, 2),
+		"PROJECT/123x456.jpg": noise(12, 45, 3),
+	})
+	src := readZipTree(t, zipPath)
+	length = 3
+	process([]string{zipPath})
+
+	reduced := readZipTree(t, filepath.Join(dir, "posters_reduced.zip"))
+	if len(reduced) != len(src) {
+		t.Fatalf("reduced archive has %v entries, want %v", len(reduced), len(src))
+	}
+	if data := reduced["PROJECT/600x600.jpg"]; len(data) >= len(src["PROJECT/600x600.jpg"]) || len(data) > 700*1000 {
+		t.Errorf("PROJECT/600x600.jpg: %v bytes of %v are not reduced", len(data), len(src["PROJECT/600x600.jpg"]))
+	} else if _, err := jpeg.Decode(bytes.NewReader(data)); err != nil {
+		t.Errorf("PROJECT/600x600.jpg: %v", err)
+	}
+	for _, name := range []string{"PROJECT/600x840.jpg", "PROJECT/123x456.jpg"} {
+		if !bytes.Equal(reduced[name], src[name]) {
+			t.Errorf("%v is changed", name)
+		}
+	}
+	statuses := map[string]string{}
+	for _, v := range reportEntries {
+		statuses[filepath.ToSlash(v.Path)] = v.Status
+	}
+	base := filepath.ToSlash(filepath.Join(dir, "posters"))
+	for name, status := range map[string]string{
+		"PROJECT/600x600.jpg": rtimg.StatusReduced,
+		"PROJECT/600x840.jpg": rtimg.StatusOk,
+		"PROJECT/123x456.jpg": rtimg.StatusError,
+	} {
+		if got := statuses[base+"/"+name]; got != status {
+			t.Errorf("%v: status %q, want %q", name, got, status)
+		}
+	}
+	html, err := ioutil.ReadFile(flagHTMLReport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(html, []byte("data:image/jpeg;base64,")) || !bytes.Contains(html, []byte("lossy: q:")) {
+		t.Errorf("html report has no archive entries")
+	}
+
+	// rename
+	resetState()
+	command, flagLayout, flagApply = cmdRename, rtimg.LayoutDir, true
+	zipPath = filepath.Join(dir, "names.zip")
+	writeZipTree(t, zipPath, map[string]image.Image{
+		"PROJECT/600X600.JPEG": noise(60, 60, 1),
+		"PROJECT/600x840.jpg":  noise(60, 84, 2),
+	})
+	process([]string{zipPath})
+	renamed := readZipTree(t, filepath.Join(dir, "names_renamed.zip"))
+	for _, name := range []string{"PROJECT/600x600.jpg", "PROJECT/600x840.jpg"} {
+		if _, ok := renamed[name]; !ok {
+			t.Errorf("renamed archive has no %v: %v", name, len(renamed))
+		}
+	}
+	if len(renamed) != 2 || len(errorsArray) != 0 {
+		t.Errorf("renamed archive has %v entries, errors %q", len(renamed), errorsArray)
+	}
+}
@@ -1,11 +1,17 @@
 module github.com/macroblock/rtimg
 
-go 1.15
+go 1.18
 
 require (
 	github.com/atotto/clipboard v0.1.4
 	github.com/macroblock/imed v0.0.0-20221223044423-676b9e457599
 	github.com/malashin/go-ansi v0.0.0-20170109082841-516580d6516a
-	github.com/mattn/go-isatty v0.0.16 // indirect
 	golang.org/x/crypto v0.0.0-20210616213533-5ff15b29337e
+	golang.org/x/text v0.13.0
+)
+
+require (
+	github.com/mattn/go-isatty v0.0.16 // indirect
+	golang.org/x/sys v0.5.0 // indirect
+	golang.org/x/term v0.0.0-20201126162022-7de9c90e9dd1 // indirect
 )
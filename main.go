@@ -1,10 +1,16 @@
 package main
 
 import (
+	"bytes"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
 	"math"
 	"os"
+	"path"
 	"path/filepath"
 	"regexp"
 	"sort"
@@ -24,10 +30,11 @@ import (
 
 var mtx sync.Mutex
 
-var count = 0            // Filecount for progress visualisation.
-var errorsArray []string // Store errors in array.
-var files []string       // Store input fileNames in global space.
-var length int           // Store the amount of input files in global space.
+var count = 0              // Filecount for progress visualisation.
+var errorsArray []string   // Store errors in array.
+var warningsArray []string // Store warnings in array (guarded by mtx).
+var files []string         // Store input fileNames in global space.
+var length int             // Store the amount of input files in global space.
 
 // Flags
 var threads int
@@ -35,10 +42,66 @@ var flagDoReduceSize bool
 var flagRecursive bool
 var flagNameFileRe string
 var flagDontUseNameFile bool
+var flagFromFile string
+var flagInclude stringList
+var flagExclude stringList
+var flagSkipUnknownExt bool
+var flagDupesDist int
+var flagBorders int
+var flagOutputDir string
+var flagPackToDir bool
+var flagLayout string
+var flagApply bool
+var flagTagCheck string
+var flagStrict bool
+var flagConfig string
+var flagProfile string
+var flagHTMLReport string
 var nameFileRe *regexp.Regexp
 
 var wg sync.WaitGroup
 
+// reduced files with ffmpeg -q:v above it are printed magenta (!!!FIXME: empirical value).
+// JPEG candidates go by q with 4:2:0 right after 4:4:4 of the same q, so it still
+// cuts the list of candidates at one place.
+const poorQ = 13
+
+const (
+	cmdDupes   = "dupes"
+	cmdPreview = "preview"
+	cmdPack    = "pack"
+	cmdRename  = "rename"
+	cmdRules   = "rules"
+)
+
+var commands = []struct {
+	name, usage string
+}{
+	{cmdDupes, "find identical and similar posters of the same size in different projects"},
+	{cmdPreview, "write copies of the images with UI overlays and safe zones of the config rules drawn over them"},
+	{cmdRename, "rename files into the canonical layout (see -layout), a preview without -apply"},
+	{cmdPack, "pack deliverables of the projects that passed the check into an archive per platform type"},
+	{cmdRules, "'rules dump' prints the table of <key>s expanded from rule templates (see -c, -profile)"},
+}
+
+var command string // Empty for the default check (and reduce) run.
+
+var dupeEntries []rtimg.TDupeEntry     // Guarded by mtx.
+var reportEntries []rtimg.TReportEntry // Guarded by mtx.
+var renames []rtimg.TRename            // Guarded by mtx.
+
+// stringList is a flag.Value that collects all occurrences of a flag.
+type stringList []string
+
+func (o *stringList) String() string {
+	return strings.Join(*o, ",")
+}
+
+func (o *stringList) Set(s string) error {
+	*o = append(*o, s)
+	return nil
+}
+
 ///////////////////////////////////////////////////////////////////////////////
 type RootDirData struct {
 	From      string
@@ -100,24 +163,123 @@ func rootDirSetError(dir string, err error) string {
 func main() {
 	// Parse input flags.
 	flag.IntVar(&threads, "t", 4, "Number of threads")
+	flag.StringVar(&flagConfig, "c", "", "json config file")
+	flag.StringVar(&flagProfile, "profile", "", "comma separated list of profiles (rt, gp, megafon, viasat, google_apple_feed...) that <key>s can match, all by default")
 	flag.BoolVar(&flagRecursive, "d", false, "Recursive walk directories (skip symlinks)")
 	flag.BoolVar(&flagDoReduceSize, "s", false, "Reduce size of the images")
 	flag.StringVar(&flagNameFileRe, "n", "", "regexp that has in the first group (cannot be an empty string) a result to rename the directory")
 	flag.BoolVar(&flagDontUseNameFile, "N", false, "do not rename directories")
+	flag.StringVar(&flagFromFile, "from-file", "", "read the list of input files from the file (newline or NUL delimited)")
+	flag.Var(&flagInclude, "include", "glob pattern of files to process while walking directories (can be repeated)")
+	flag.Var(&flagExclude, "exclude", "glob pattern of files or directories to skip while walking directories (can be repeated)")
+	flag.BoolVar(&flagSkipUnknownExt, "x", false, "silently skip files with unsupported extensions while walking directories")
+	flag.StringVar(&flagTagCheck, "tag-check", rtimg.CheckWarn, "how to report file name tags that disagree with the project name: off, warn, error")
+	flag.BoolVar(&flagStrict, "strict", false, "treat warnings as errors (severities are set per rule and profile in the config)")
+	flag.IntVar(&flagBorders, "b", 0, "report uniform borders of at least this thickness (px) where they are not allowed, 0 - do not check")
+	flag.StringVar(&flagHTMLReport, "html", "", "write an html report with thumbnails to the file")
+	flag.StringVar(&flagOutputDir, "o", "rtimg_out", "output directory (preview and pack commands)")
+	flag.BoolVar(&flagPackToDir, "pack-dir", false, "write directory trees instead of zip archives (pack command)")
+	flag.StringVar(&flagLayout, "layout", rtimg.LayoutDir, "target layout: '"+rtimg.LayoutDir+"' - <project>/<size>.<ext>, '"+rtimg.LayoutTagname+"' - the tagname with the size of the <key> (rename command)")
+	flag.BoolVar(&flagApply, "apply", false, "do rename files, otherwise only show what would be done (rename command)")
+	flag.IntVar(&flagDupesDist, "dupes-dist", 6, "max perceptual hash distance (bits) to treat images as similar (dupes command)")
 
 	flag.Usage = func() {
-		ansi.Println("Usage: rtimg [options] [file1 file2 ...]")
+		ansi.Println("Usage: rtimg [command] [options] [file1 file2 ...]")
+		ansi.Println("       use '-' as a file name to read the list of input files from stdin")
+		ansi.Println("       zip archives are checked as directories")
+		ansi.Println("Commands:")
+		for _, v := range commands {
+			ansi.Println("  " + v.name + "\t" + v.usage)
+		}
 		flag.PrintDefaults()
 	}
+	if len(os.Args) > 1 {
+		for _, v := range commands {
+			if os.Args[1] == v.name {
+				command = v.name
+				os.Args = append(os.Args[:1], os.Args[2:]...)
+				break
+			}
+		}
+	}
+	if command == cmdRules {
+		if len(os.Args) < 2 || os.Args[1] != "dump" {
+			fmt.Printf("fatal error: usage: rtimg rules dump [options]\n")
+			os.Exit(1)
+		}
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+	}
 	flag.Parse()
 
-	files = flag.Args()
+	list, err := collectInputs(flagFromFile, flag.Args())
+	if err != nil {
+		fmt.Printf("fatal error: %v\n", err)
+		os.Exit(1)
+	}
+	files = list
 	length = len(files)
 
+	for _, pattern := range append(append([]string{}, flagInclude...), flagExclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			fmt.Printf("fatal error: glob %q: %v\n", pattern, err)
+			os.Exit(1)
+		}
+	}
+
+	if flagConfig != "" {
+		if err := rtimg.LoadConfig(flagConfig); err != nil {
+			fmt.Printf("fatal error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if flagProfile != "" {
+		if err := rtimg.SetProfiles(strings.Split(flagProfile, ",")); err != nil {
+			fmt.Printf("fatal error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if command == cmdRules {
+		printRules(rtimg.Rules())
+		os.Exit(0)
+	}
+
+	rtimg.BorderThickness = flagBorders
+	rtimg.Strict = flagStrict
+	switch flagTagCheck {
+	default:
+		fmt.Printf("fatal error: unknown -tag-check value %q\n", flagTagCheck)
+		os.Exit(1)
+	case rtimg.CheckOff, rtimg.CheckWarn, rtimg.CheckError:
+		rtimg.NameMismatchCheck = flagTagCheck
+	}
+
 	if flagNameFileRe != "" {
 		nameFileRe = regexp.MustCompile(flagNameFileRe)
 	}
 
+	if command == cmdRename && flagLayout != rtimg.LayoutDir && flagLayout != rtimg.LayoutTagname {
+		fmt.Printf("fatal error: unknown layout %q\n", flagLayout)
+		os.Exit(1)
+	}
+	rtimg.TagnameParser = func(filePath string) (rtimg.ITagname, error) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		tn, err := tagname.NewFromFilename(filePath, true)
+		if err != nil {
+			return nil, err
+		}
+		return tn, nil
+	}
+
+	if command == cmdPreview || command == cmdPack {
+		if err := os.MkdirAll(flagOutputDir, 0755); err != nil {
+			fmt.Printf("fatal error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
 	if clipboard.Unsupported {
 		appendError("--clipboard--", fmt.Errorf("clipboard unsupported for the OS"))
 	}
@@ -127,6 +289,39 @@ func main() {
 		os.Exit(1)
 	}
 
+	process(files)
+
+	if len(warningsArray) > 0 {
+		ansi.Println("\x1b[0m\nWARNINGS\n========")
+		for _, v := range warningsArray {
+			ansi.Println(v)
+		}
+		ansi.Println("\x1b[0m========")
+	}
+
+	// If there were any errors.
+	if len(errorsArray) > 0 {
+		// Print out all the errors from the error array.
+		ansi.Println("\x1b[0m\nERRORS\n========")
+		for i := 0; i < len(errorsArray); i++ {
+			ansi.Println(errorsArray[i])
+		}
+		ansi.Println("\x1b[0m========")
+
+		// Don't close the terminal window.
+		ansi.Println("Press any key to exit...")
+		err := waitForAnyKey()
+		if err != nil {
+			ansi.Println("\x1b[31;1m"+"    [waitForAnyKey]:", err, "\x1b[0m")
+		}
+	}
+}
+
+// process checks the files (walking directories if the recursive flag is set) with
+// a pool of workers and then runs the final phase of the command.
+func process(files []string) {
+	defer closeArchives()
+
 	// Create channel for goroutines
 	c := make(chan string)
 
@@ -153,7 +348,81 @@ func main() {
 	close(c)
 	wg.Wait()
 
-	// rename directories
+	switch command {
+	case cmdDupes:
+		printDupes(rtimg.FindDupes(dupeEntries, flagDupesDist))
+	case cmdPack:
+		packProjects()
+	case cmdRename:
+		renameFiles()
+	case "":
+		errorsArray = append(errorsArray, renameRootDirs()...)
+		if flagHTMLReport != "" {
+			if err := writeHTMLReport(flagHTMLReport); err != nil {
+				appendError(flagHTMLReport, err)
+			}
+		}
+	}
+}
+
+// collectInputs gathers input paths from the command line arguments, the list file
+// (if any) and stdin (if one of the arguments is '-').
+func collectInputs(listFile string, args []string) ([]string, error) {
+	ret := []string{}
+	if listFile != "" {
+		data, err := ioutil.ReadFile(listFile)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, splitFileList(data)...)
+	}
+	stdinWasRead := false
+	for _, arg := range args {
+		if arg != "-" {
+			ret = append(ret, arg)
+			continue
+		}
+		if stdinWasRead {
+			continue
+		}
+		stdinWasRead = true
+		list, err := readFileList(os.Stdin)
+		if err != nil {
+			return nil, fmt.Errorf("stdin: %v", err)
+		}
+		ret = append(ret, list...)
+	}
+	return ret, nil
+}
+
+func readFileList(r io.Reader) ([]string, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return splitFileList(data), nil
+}
+
+// splitFileList splits data by NUL if there is at least one NUL in it (find -print0)
+// and by newlines otherwise. Empty entries are skipped.
+func splitFileList(data []byte) []string {
+	sep := []byte("\n")
+	if bytes.IndexByte(data, 0) >= 0 {
+		sep = []byte{0}
+	}
+	ret := []string{}
+	for _, v := range bytes.Split(data, sep) {
+		s := strings.TrimRight(string(v), "\r")
+		if s == "" {
+			continue
+		}
+		ret = append(ret, s)
+	}
+	return ret
+}
+
+// renameRootDirs renames directories that have a name file and were processed without errors.
+func renameRootDirs() []string {
 	dirlist := []RootDirData{}
 	for k, v := range rootDirMap {
 		// // debug print
@@ -191,39 +460,67 @@ func main() {
 				fmt.Sprintf("%v: rename: %v", v.From, err))
 		}
 	}
-	errorsArray = append(errorsArray, errlist...)
-
-	// If there were any errors.
-	if len(errorsArray) > 0 {
-		// Print out all the errors from the error array.
-		ansi.Println("\x1b[0m\nERRORS\n========")
-		for i := 0; i < len(errorsArray); i++ {
-			ansi.Println(errorsArray[i])
-		}
-		ansi.Println("\x1b[0m========")
+	return errlist
+}
 
-		// Don't close the terminal window.
-		ansi.Println("Press any key to exit...")
-		err := waitForAnyKey()
-		if err != nil {
-			ansi.Println("\x1b[31;1m"+"    [waitForAnyKey]:", err, "\x1b[0m")
-		}
+// WalkPath returns files to process of the directory (or the file itself) on the os
+// file system.
+func WalkPath(root string) ([]string, error) {
+	root = filepath.Clean(root)
+	dir, name := filepath.Dir(root), filepath.Base(root)
+	if !fs.ValidPath(name) {
+		dir, name = root, "."
 	}
+	w := &tWalker{
+		fsys: os.DirFS(dir),
+		join: func(p string) string {
+			return filepath.Join(dir, filepath.FromSlash(p))
+		},
+		nameFiles: true,
+	}
+	list, err := w.walk(name)
+	for i := range list {
+		list[i] = w.join(list[i])
+	}
+	return list, err
+}
+
+// tWalker lists files to process of a file system (a directory on disk, an archive,
+// an in-memory tree).
+type tWalker struct {
+	fsys fs.FS
+	// maps slash separated paths of fsys to the reported ones
+	join func(string) string
+	// look for files with a name to rename the directory (see -n)
+	nameFiles bool
 }
 
-func WalkPath(path string) ([]string, error) {
+// walk returns (slash separated) paths of fsys to process.
+func (o *tWalker) walk(root string) ([]string, error) {
 	ret := []string{}
-	err := filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
+	ignores := map[string][]string{}
+	err := fs.WalkDir(o.fsys, root, func(p string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
-		if info.IsDir() {
+		if d.IsDir() {
+			if p != root && isSkipped(root, p, ignores) {
+				return fs.SkipDir
+			}
+			patterns, err := readIgnoreFile(o.fsys, p)
+			if err != nil {
+				setError(o.join(p), err)
+			}
+			ignores[p] = patterns
+			return nil
+		}
+		filename := path.Base(p)
+		if filename == ignoreFileName {
 			return nil
 		}
 		// check if it is the specified filename that contains a name to rename the directory
-		if nameFileRe != nil {
-			filename := filepath.Base(path)
-			dir := filepath.Dir(path)
+		if o.nameFiles && nameFileRe != nil {
+			dir := o.join(path.Dir(p))
 			val := nameFileRe.FindAllStringSubmatch(filename, -1)
 			if val != nil && len(val) == 1 && len(val[0]) == 2 && val[0][1] != "" {
 				err := rootDirSetName(dir, val[0][1])
@@ -233,17 +530,96 @@ func WalkPath(path string) ([]string, error) {
 				return nil
 			}
 			if val != nil {
-				setError(path, fmt.Errorf("incorrect result of regexp %q", flagNameFileRe))
+				setError(o.join(p), fmt.Errorf("incorrect result of regexp %q", flagNameFileRe))
 				return nil
 			}
 		}
-		ret = append(ret, path)
+		if isSkipped(root, p, ignores) {
+			return nil
+		}
+		if len(flagInclude) > 0 && !matchGlobs(flagInclude, relPath(root, p)) {
+			return nil
+		}
+		if flagSkipUnknownExt && !rtimg.IsValidExtension(path.Ext(p)) && !isArchive(p) {
+			return nil
+		}
+		ret = append(ret, p)
 		return nil
 	})
 
 	return ret, err
 }
 
+const ignoreFileName = ".rtimgignore"
+
+// isSkipped reports whether the (slash separated) path is excluded by the -exclude
+// flags or by any .rtimgignore file found in the directories between root and the path.
+func isSkipped(root, p string, ignores map[string][]string) bool {
+	if matchGlobs(flagExclude, relPath(root, p)) {
+		return true
+	}
+	for dir := path.Dir(p); ; dir = path.Dir(dir) {
+		if patterns := ignores[dir]; len(patterns) > 0 && matchGlobs(patterns, relPath(dir, p)) {
+			return true
+		}
+		if dir == root || dir == "." || dir == "/" {
+			return false
+		}
+	}
+}
+
+// matchGlobs matches patterns without a slash against the base name and
+// patterns with a slash against the whole (slash separated) relative path.
+func matchGlobs(patterns []string, rel string) bool {
+	base := path.Base(rel)
+	for _, pattern := range patterns {
+		target := base
+		if strings.Contains(pattern, "/") {
+			target = rel
+		}
+		if ok, _ := path.Match(pattern, target); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// relPath returns the slash separated path relative to the directory or, if it is
+// the directory itself, its base name.
+func relPath(dir, p string) string {
+	switch {
+	case dir == p:
+		return path.Base(p)
+	case dir == ".":
+		return p
+	}
+	return strings.TrimPrefix(p, dir+"/")
+}
+
+// readIgnoreFile reads glob patterns (one per line, '#' starts a comment) from
+// the .rtimgignore file of the directory. A missing file is not an error.
+func readIgnoreFile(fsys fs.FS, dir string) ([]string, error) {
+	data, err := fs.ReadFile(fsys, path.Join(dir, ignoreFileName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	ret := []string{}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if _, err := path.Match(line, ""); err != nil {
+			return ret, fmt.Errorf("%v: glob %q: %v", ignoreFileName, line, err)
+		}
+		ret = append(ret, line)
+	}
+	return ret, nil
+}
+
 func worker(c chan string) {
 	defer wg.Done()
 	for filePath := range c {
@@ -251,68 +627,352 @@ func worker(c chan string) {
 	}
 }
 
+// tFile - a file to process: a file on disk or an entry of an archive.
+type tFile struct {
+	fsys fs.FS
+	// the slash separated name of the file in fsys
+	name string
+	// the absolute path to find a <key> by (a virtual one for an archive entry)
+	path string
+	// the path to report
+	reportPath string
+	// the archive of an entry (nil - a file on disk)
+	archive *tArchive
+}
+
 func workerProcess(filePath string) {
-	fileNamePath := filePath
-	fileName := filepath.Base(filePath)
-	filePath, err := filepath.Abs(filePath)
+	if isArchive(filePath) {
+		archiveProcess(filePath)
+		return
+	}
+	absPath, err := filepath.Abs(filePath)
 	if err != nil {
-		setError(fileNamePath, err)
+		setError(filePath, err)
 		return
 	}
+	file := tFile{path: absPath, reportPath: filePath}
+	file.fsys, file.name = rtimg.DirFS(absPath)
+	processFile(file)
+}
+
+// processFile checks the file and does the command with it.
+func processFile(file tFile) {
+	fileNamePath := file.reportPath
+	fileName := filepath.Base(file.path)
+	filePath := file.path
 
 	mtx.Lock()
 	// !!!TODO!!! something with deep check
-	tn, err := tagname.NewFromFilename(filePath, true)
+	tn, err := tagname.NewFromFilename(filePath, file.archive == nil)
 	mtx.Unlock()
 	if err != nil {
 		tn = nil
 	}
 
-	data, err := rtimg.CheckImage(filePath, tn)
-	if err != nil {
+	entry := &rtimg.TReportEntry{Path: fileNamePath, Status: rtimg.StatusError, Season: -1, Episode: -1, Size: -1, Limit: -1, Q: -1}
+	if file.archive != nil {
+		entry.FS, entry.FSName = file.fsys, file.name
+	}
+	if (flagHTMLReport != "" && command == "") || command == cmdPack {
+		defer addReportEntry(entry, file, tn)
+	}
+	fail := func(err error) {
+		entry.Message = err.Error()
 		setError(fileNamePath, err)
+	}
+	pass := func(warnings []string) {
+		entry.Status = rtimg.StatusOk
+		if len(warnings) > 0 {
+			entry.Status = rtimg.StatusWarning
+		}
+		printOk(fileName, warnings)
+	}
+
+	res, err := rtimg.CheckImageFS(file.fsys, file.name, filePath, tn)
+	if err != nil {
+		fail(err)
+		return
+	}
+	warnings := res.Warnings
+	appendWarnings(fileNamePath, warnings)
+	entry.SetKey(res.Key)
+	entry.Warnings = warnings
+	for _, v := range res.Findings {
+		if v.Severity == rtimg.SeverityInfo {
+			entry.Infos = append(entry.Infos, v.Message)
+		}
+	}
+
+	switch command {
+	case cmdDupes:
+		addDupeEntry(fileNamePath, file, res.Key)
+		return
+	case cmdPreview:
+		writePreview(fileNamePath, file, res.Key)
+		return
+	case cmdRename:
+		addRename(fileNamePath, file, tn)
 		return
 	}
 
-	sizeLimit := data.FileSizeLimit
+	sizeLimit := res.Limit
 	if sizeLimit < 0 {
 		// RenameRootDir(filePath)
-		printGreen(fileName, "Ok")
+		pass(warnings)
 		return
 	}
+	entry.Limit = sizeLimit
 
-	inputSize, err := rtimg.GetFileSize(filePath)
+	info, err := fs.Stat(file.fsys, file.name)
 	if err != nil {
-		setError(fileNamePath, err)
+		fail(err)
 		return
 	}
+	inputSize := info.Size()
+	entry.Size = inputSize
 
 	if !flagDoReduceSize {
 		if inputSize > sizeLimit {
-			setError(fileNamePath, fmt.Errorf("%v KB > %v KB", inputSize/1000, sizeLimit/1000))
+			fail(&rtimg.TSizeError{Size: inputSize, Limit: sizeLimit})
 		} else {
-			printGreen(fileName, "Ok")
+			pass(warnings)
 		}
 		return
 	}
 
-	outputSize, q, err := rtimg.ReduceImage(filePath, data.FileSizeLimit)
+	reduced, err := reduceFile(file, sizeLimit, res.Key.Data())
 	if err != nil {
-		setError(fileNamePath, err)
+		fail(err)
 		return
 	}
-	if inputSize == outputSize {
-		printGreen(fileName, "Ok")
+	if inputSize == reduced.Size {
+		pass(warnings)
 		return
 	}
-	msg := fmt.Sprintf("%v KB < %v KB, q: %v d: %v", outputSize/1000, sizeLimit/1000, q, inputSize-outputSize)
-	if q > 13 { // !!!FIXME: empirical value
+	entry.Status = rtimg.StatusReduced
+	entry.Size = reduced.Size
+	entry.Q = reduced.Q
+	entry.Params = reduced.Params
+	entry.Stage = reduced.Stage
+	msg := fmt.Sprintf("%v KB < %v KB, %v: %v d: %v", reduced.Size/1000, sizeLimit/1000, reduced.Stage, reduced.Params, inputSize-reduced.Size)
+	msg = withWarnings(msg, warnings)
+	if reduced.Q > poorQ {
 		printMagenta(fileName, msg)
 	} else {
 		printYellow(fileName, msg)
 	}
 }
 
+// reduceFile reduces the file on disk in place or the archive entry in a copy that
+// replaces it.
+func reduceFile(file tFile, sizeLimit int64, data *rtimg.TKeyData) (*rtimg.TReduceResult, error) {
+	if file.archive != nil {
+		return file.archive.reduce(file.name, sizeLimit, data)
+	}
+	return rtimg.ReduceImage(file.path, sizeLimit, data)
+}
+
+// addReportEntry completes the entry with <key> data and a thumbnail and stores it for the html report.
+func addReportEntry(entry *rtimg.TReportEntry, file tFile, tn rtimg.ITagname) {
+	// the <key> is already stored if the check has passed
+	if entry.Hash == "" {
+		key, err := rtimg.FindKey(file.path, tn)
+		if err == nil {
+			entry.SetKey(key)
+		} else {
+			for _, v := range rtimg.Suggest(file.path, 3) {
+				entry.Suggestions = append(entry.Suggestions, v.String())
+			}
+		}
+	}
+	if flagHTMLReport != "" {
+		// thumbnails of the undecodable files are just skipped
+		entry.Thumb, _ = rtimg.Thumbnail(file.fsys, file.name, 200)
+	}
+	mtx.Lock()
+	reportEntries = append(reportEntries, *entry)
+	mtx.Unlock()
+}
+
+// addRename plans renaming of the file into the canonical layout.
+func addRename(fileNamePath string, file tFile, tn rtimg.ITagname) {
+	fileName := filepath.Base(file.path)
+	to, err := rtimg.CanonicalPath(file.path, tn, flagLayout)
+	if err == nil && file.archive != nil {
+		err = file.archive.addRename(file.name, to)
+	}
+	if err != nil {
+		setError(fileNamePath, err)
+		return
+	}
+	if file.archive == nil {
+		mtx.Lock()
+		renames = append(renames, rtimg.TRename{From: file.path, To: to})
+		mtx.Unlock()
+	}
+	printGreen(fileName, "Ok")
+}
+
+// renameFiles prints planned renames and applies them if the apply flag is set.
+func renameFiles() {
+	list, errs := rtimg.PlanRenames(renames)
+	for _, err := range errs {
+		errorsArray = append(errorsArray, "\x1b[31;1mrename: "+err.Error()+"\x1b[0m")
+	}
+	ansi.Println("\x1b[0m\nRENAME\n========")
+	for _, v := range list {
+		status := "\x1b[33;1mpreview\x1b[0m"
+		if flagApply {
+			status = "\x1b[32;1mdone\x1b[0m"
+			if err := rtimg.ApplyRename(v); err != nil {
+				status = "\x1b[31;1m" + err.Error() + "\x1b[0m"
+				appendError(v.From, err)
+			}
+		}
+		printRename(status, v.From, v.To)
+	}
+	n := len(list)
+	for _, v := range archives {
+		n += v.renameEntries()
+	}
+	if n == 0 {
+		ansi.Println("nothing to rename")
+	}
+	ansi.Println("========")
+}
+
+func printRename(status, from, to string) {
+	ansi.Println(status + " " + from + " -> \x1b[35m" + to + "\x1b[0m")
+}
+
+// packProjects writes packages of the projects that passed the check to the output directory.
+func packProjects() {
+	packages, errs := rtimg.PlanPackages(reportEntries)
+	for _, err := range errs {
+		errorsArray = append(errorsArray, "\x1b[31;1mpack: "+err.Error()+"\x1b[0m")
+	}
+	length = len(packages)
+	count = 0
+	for _, pack := range packages {
+		write := pack.WriteZip
+		if flagPackToDir {
+			write = pack.WriteDir
+		}
+		path, err := write(flagOutputDir)
+		if err != nil {
+			setError(filepath.Join(flagOutputDir, pack.FileName()), err)
+			continue
+		}
+		printGreen(filepath.Base(path), fmt.Sprintf("%v file(s)", len(pack.Files)))
+	}
+}
+
+// writeHTMLReport writes the html report of the run to the file.
+func writeHTMLReport(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = rtimg.WriteHTMLReport(f, reportEntries)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// addDupeEntry computes a perceptual hash of the image and stores it for the dupes report.
+func addDupeEntry(fileNamePath string, file tFile, key *rtimg.TKey) {
+	fileName := filepath.Base(file.path)
+	ext := rtimg.NormalizeExt(filepath.Ext(file.path))
+	if ext != ".jpg" && ext != ".png" {
+		printGreen(fileName, "Ok (not hashed)")
+		return
+	}
+	phash, err := rtimg.ImageHash(file.fsys, file.name)
+	if errors.Is(err, rtimg.ErrUniform) {
+		printGreen(fileName, "Ok (uniform, not hashed)")
+		return
+	}
+	if err != nil {
+		setError(fileNamePath, err)
+		return
+	}
+	sum, err := rtimg.FileSum(file.fsys, file.name)
+	if err != nil {
+		setError(fileNamePath, err)
+		return
+	}
+	mtx.Lock()
+	dupeEntries = append(dupeEntries, rtimg.TDupeEntry{
+		Path:       fileNamePath,
+		ProjectDir: key.ProjectDir(),
+		Hash:       key.Hash(),
+		PHash:      phash,
+		Sum:        sum,
+	})
+	mtx.Unlock()
+	printGreen(fileName, fmt.Sprintf("Ok %016x", phash))
+}
+
+// writePreview writes a copy of the image with its UI overlays and safe zones
+// to the preview directory.
+func writePreview(fileNamePath string, file tFile, key *rtimg.TKey) {
+	fileName := filepath.Base(file.path)
+	zones := rtimg.ZonesFor(key)
+	ext := rtimg.NormalizeExt(filepath.Ext(file.path))
+	if len(zones) == 0 || (ext != ".jpg" && ext != ".png") {
+		printGreen(fileName, "Ok (no preview)")
+		return
+	}
+	name := previewName(key)
+	err := rtimg.WritePreview(file.fsys, file.name, filepath.Join(flagOutputDir, name), zones)
+	if err != nil {
+		setError(fileNamePath, err)
+		return
+	}
+	printGreen(fileName, "Ok -> "+name)
+}
+
+// previewName flattens the project path and the hash into a file name, so projects
+// with the same name in different directories do not overwrite each other's previews.
+func previewName(key *rtimg.TKey) string {
+	parts := []string{}
+	for _, v := range strings.Split(filepath.ToSlash(filepath.Join(key.ProjectDir(), key.Hash())), "/") {
+		if v != "" && v != "." && v != ".." && !strings.HasSuffix(v, ":") {
+			parts = append(parts, v)
+		}
+	}
+	return strings.Join(parts, "_") + ".png"
+}
+
+func printDupes(groups []rtimg.TDupeGroup) {
+	ansi.Println("\x1b[0m\nDUPLICATES\n========")
+	for _, group := range groups {
+		c, kind := "33", "similar"
+		if group.Identical {
+			c, kind = "35", "identical"
+		}
+		ansi.Println("\x1b[" + c + ";1m" + group.Hash + " (" + kind + ")\x1b[0m")
+		for _, entry := range group.Entries {
+			ansi.Println("    " + entry.Path + " \x1b[30;1m" + fmt.Sprintf("%016x", entry.PHash) + "\x1b[0m")
+		}
+	}
+	if len(groups) == 0 {
+		ansi.Println("no duplicates found")
+	}
+	ansi.Println("========")
+}
+
+func printRules(rules []rtimg.TRule) {
+	for _, v := range rules {
+		limit := "-"
+		if v.FileSizeLimit >= 0 {
+			limit = fmt.Sprintf("%v KB", v.FileSizeLimit/1000)
+		}
+		fmt.Printf("%-18v %-4v %9v  %v\n", v.Profile, v.Type, limit, v.Hash)
+	}
+}
+
 // round rounds floats into integer numbers.
 func round(input float64) int {
 	if input < 0 {
@@ -361,6 +1021,31 @@ func appendError(filename string, err error) {
 		filepath.Base(filename)+" ->\x1b[35m"+filepath.Dir(filename))
 }
 
+func appendWarnings(filename string, warnings []string) {
+	mtx.Lock()
+	defer mtx.Unlock()
+	for _, v := range warnings {
+		warningsArray = append(warningsArray, "\x1b[33;1m"+v+"\x1b[0m "+
+			filepath.Base(filename)+" ->\x1b[35m"+filepath.Dir(filename))
+	}
+}
+
+func withWarnings(message string, warnings []string) string {
+	if len(warnings) == 0 {
+		return message
+	}
+	return message + ", warning: " + strings.Join(warnings, "; ")
+}
+
+// printOk prints Ok in green or, if there are warnings, in yellow.
+func printOk(filename string, warnings []string) {
+	if len(warnings) > 0 {
+		printYellow(filename, withWarnings("Ok", warnings))
+		return
+	}
+	printGreen(filename, "Ok")
+}
+
 func setError(path string, err error) {
 	// projectDir := rtimg.GetProjectDir(path)
 	// if projectDir == "" {
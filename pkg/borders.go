@@ -0,0 +1,99 @@
+package rtimg
+
+import (
+	"fmt"
+	"image"
+)
+
+// TBorder - a uniform band along one of the image edges.
+type TBorder struct {
+	Side      string
+	Thickness int
+	Luma      uint8
+}
+
+// BorderThickness - bands (letterbox, pillarbox, frames) at least this thick are
+// reported as FindingBorder unless its severity is SeverityOff for the <key>.
+// 0 disables the check.
+var BorderThickness = 0
+
+// max deviation of a channel (8 bit) from the band color
+const borderTolerance = 8
+
+func (o TBorder) String() string {
+	name := "uniform"
+	switch {
+	case o.Luma < 16:
+		name = "black"
+	case o.Luma > 239:
+		name = "white"
+	}
+	return fmt.Sprintf("%v border at %v (%vpx)", name, o.Side, o.Thickness)
+}
+
+// FindBorders returns uniform bands along the edges that are at least minThickness
+// pixels thick. Bands that cover the whole image are not reported.
+func FindBorders(img image.Image, minThickness int) []TBorder {
+	rect := img.Bounds()
+	sides := []struct {
+		name string
+		// n-th line from the edge as a start point and a step along the line
+		line  func(n int) (image.Point, image.Point, int)
+		count int
+	}{
+		{"top", func(n int) (image.Point, image.Point, int) {
+			return image.Pt(rect.Min.X, rect.Min.Y+n), image.Pt(1, 0), rect.Dx()
+		}, rect.Dy()},
+		{"bottom", func(n int) (image.Point, image.Point, int) {
+			return image.Pt(rect.Min.X, rect.Max.Y-1-n), image.Pt(1, 0), rect.Dx()
+		}, rect.Dy()},
+		{"left", func(n int) (image.Point, image.Point, int) {
+			return image.Pt(rect.Min.X+n, rect.Min.Y), image.Pt(0, 1), rect.Dy()
+		}, rect.Dx()},
+		{"right", func(n int) (image.Point, image.Point, int) {
+			return image.Pt(rect.Max.X-1-n, rect.Min.Y), image.Pt(0, 1), rect.Dy()
+		}, rect.Dx()},
+	}
+
+	ret := []TBorder{}
+	for _, side := range sides {
+		start, _, _ := side.line(0)
+		r0, g0, b0 := rgb8(img, start)
+		thickness := 0
+		for ; thickness < side.count; thickness++ {
+			p, step, length := side.line(thickness)
+			if !isUniformLine(img, p, step, length, r0, g0, b0) {
+				break
+			}
+		}
+		if thickness < minThickness || thickness == side.count {
+			continue
+		}
+		luma := (299*int(r0) + 587*int(g0) + 114*int(b0)) / 1000
+		ret = append(ret, TBorder{Side: side.name, Thickness: thickness, Luma: uint8(luma)})
+	}
+	return ret
+}
+
+func rgb8(img image.Image, p image.Point) (int, int, int) {
+	r, g, b, _ := img.At(p.X, p.Y).RGBA()
+	return int(r >> 8), int(g >> 8), int(b >> 8)
+}
+
+func isUniformLine(img image.Image, p, step image.Point, length int, r0, g0, b0 int) bool {
+	for i := 0; i < length; i++ {
+		r, g, b := rgb8(img, p)
+		if abs(r-r0) > borderTolerance || abs(g-g0) > borderTolerance || abs(b-b0) > borderTolerance {
+			return false
+		}
+		p = p.Add(step)
+	}
+	return true
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
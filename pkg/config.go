@@ -0,0 +1,102 @@
+package rtimg
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+)
+
+// TConfig - settings loaded from a json file. Absent sections keep built-in defaults.
+type TConfig struct {
+	// directories of series (seasons, episodes, extras) that are not projects themselves
+	Structure []TStructureRule `json:"structure"`
+	// partner subdirectories, merged with built-in ones by name
+	Subtrees []TSubtree `json:"subtrees"`
+	// rule templates added to built-in ones, overriding <key>s with the same hash
+	Rules []TRuleTemplate `json:"rules"`
+	// extension -> extension of <key>s it is matched as (".jpeg": ".jpg"), added to built-in ones
+	ExtAliases map[string]string `json:"ext_aliases"`
+	// kind of a finding -> severity, added to built-in ones
+	Severity map[string]string `json:"severity"`
+	// profile -> kind of a finding -> severity
+	ProfileSeverity map[string]map[string]string `json:"profile_severity"`
+	// PNG reduction strategies from the best quality to the worst, replace built-in ones
+	PNGStrategies []TPNGStrategy `json:"png_strategies"`
+}
+
+// LoadConfig reads the config file and applies it.
+func LoadConfig(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	config := &TConfig{}
+	if err := json.Unmarshal(data, config); err != nil {
+		return fmt.Errorf("config %v: %v", path, err)
+	}
+	if err := config.Apply(); err != nil {
+		return fmt.Errorf("config %v: %v", path, err)
+	}
+	return nil
+}
+
+// Apply replaces (or, for subtrees, rules, aliases and severities, extends) built-in rules with the ones from the config.
+func (o *TConfig) Apply() error {
+	if o.Structure != nil {
+		rules, err := compileStructure(o.Structure)
+		if err != nil {
+			return err
+		}
+		structureRules = rules
+	}
+	if o.Subtrees != nil {
+		list, err := mergeSubtrees(subtrees, o.Subtrees)
+		if err != nil {
+			return err
+		}
+		subtrees = list
+	}
+	if o.Rules != nil {
+		if err := checkTemplates(o.Rules); err != nil {
+			return err
+		}
+		ruleTemplates = append(append([]TRuleTemplate{}, ruleTemplates...), o.Rules...)
+	}
+	if o.Severity != nil {
+		if err := checkSeverities(o.Severity); err != nil {
+			return err
+		}
+		severities = mergeSeverities(severities, o.Severity)
+	}
+	if o.PNGStrategies != nil {
+		if err := checkPNGStrategies(o.PNGStrategies); err != nil {
+			return err
+		}
+		pngStrategies = o.PNGStrategies
+	}
+	if o.ExtAliases != nil {
+		aliases, err := mergeExtAliases(extAliases, o.ExtAliases)
+		if err != nil {
+			return err
+		}
+		extAliases = aliases
+	}
+	if o.Subtrees != nil || o.Rules != nil || o.ExtAliases != nil {
+		rebuildTable()
+	}
+	for profile, list := range o.ProfileSeverity {
+		if !contains(Profiles(), profile) {
+			return fmt.Errorf("severity: unknown profile %q", profile)
+		}
+		if err := checkSeverities(list); err != nil {
+			return fmt.Errorf("profile %v: %v", profile, err)
+		}
+		ret := map[string]map[string]string{}
+		for k, v := range profileSeverities {
+			ret[k] = v
+		}
+		ret[profile] = mergeSeverities(ret[profile], list)
+		profileSeverities = ret
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package rtimg
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"io"
+	"io/fs"
+	"math"
+	"path"
+	"strings"
+)
+
+const (
+	// images with a luminance standard deviation below this are near-uniform
+	uniformStdDev = 2.0
+	// images with a luminance entropy (bits) below this carry almost no information
+	lowEntropy = 1.0
+)
+
+var psdSignature = []byte("8BPS")
+
+// CheckContent fully decodes the image name of the file system and returns findings
+// about its content including borders forbidden for the <key> (if key is not nil).
+// Decode errors (including truncated data) are returned as an error.
+// Formats without a decoder (psd) get only a signature check, so only the signature
+// is read, and files of other formats are not read at all.
+func CheckContent(fsys fs.FS, name string, key *TKey) ([]TFinding, error) {
+	ext := NormalizeExt(path.Ext(name))
+	switch ext {
+	case ".psd":
+		return nil, checkPSD(fsys, name)
+	case ".jpg", ".png":
+	default:
+		return nil, nil
+	}
+
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("empty file")
+	}
+
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("corrupt %v: %v", strings.TrimPrefix(ext, "."), err)
+	}
+	if "."+format != ext && !(format == "jpeg" && ext == ".jpg") {
+		return nil, fmt.Errorf("%v data in a %v file", format, ext)
+	}
+	ret := []TFinding(nil)
+	if key != nil && BorderThickness > 0 && severityOf(FindingBorder, key.Data()) != SeverityOff {
+		list := []string{}
+		for _, border := range FindBorders(img, BorderThickness) {
+			list = append(list, border.String())
+		}
+		if len(list) > 0 {
+			ret = append(ret, TFinding{Kind: FindingBorder, Message: strings.Join(list, ", ")})
+		}
+	}
+	return append(ret, contentFindings(img)...), nil
+}
+
+// checkPSD reads only the beginning of the file and compares it with the signature.
+func checkPSD(fsys fs.FS, name string) error {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	buf := make([]byte, len(psdSignature))
+	n, err := io.ReadFull(f, buf)
+	if err == io.EOF {
+		return fmt.Errorf("empty file")
+	}
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	if !bytes.Equal(buf[:n], psdSignature) {
+		return fmt.Errorf("corrupt psd: invalid signature")
+	}
+	return nil
+}
+
+func contentFindings(img image.Image) []TFinding {
+	ret := []TFinding(nil)
+	mean, stdDev, entropy := lumaStats(img)
+	if stdDev < uniformStdDev {
+		ret = append(ret, TFinding{Kind: FindingUniform, Message: fmt.Sprintf("near-uniform image (mean luma %.0f)", mean)})
+	} else if entropy < lowEntropy {
+		ret = append(ret, TFinding{Kind: FindingLowEntropy, Message: fmt.Sprintf("very low entropy (%.2f bits)", entropy)})
+	}
+	return ret
+}
+
+// lumaStats returns mean, standard deviation and entropy (bits) of the 8 bit luminance.
+func lumaStats(img image.Image) (float64, float64, float64) {
+	hist := [256]int{}
+	rect := img.Bounds()
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			r, g, b, _ := img.At(x, y).RGBA()
+			luma := (299*r + 587*g + 114*b) / 1000
+			hist[luma>>8]++
+		}
+	}
+	total := float64(rect.Dx() * rect.Dy())
+	if total == 0 {
+		return 0, 0, 0
+	}
+	mean := 0.0
+	for v, n := range hist {
+		mean += float64(v * n)
+	}
+	mean /= total
+	variance, entropy := 0.0, 0.0
+	for v, n := range hist {
+		if n == 0 {
+			continue
+		}
+		p := float64(n) / total
+		variance += p * (float64(v) - mean) * (float64(v) - mean)
+		entropy -= p * math.Log2(p)
+	}
+	return mean, math.Sqrt(variance), entropy
+}
@@ -0,0 +1,145 @@
+package rtimg
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func writeJPG(t *testing.T, path string, img image.Image, truncate int) {
+	buf := &bytes.Buffer{}
+	if err := jpeg.Encode(buf, img, &jpeg.Options{Quality: 90}); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	data = data[:len(data)-truncate]
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestCheckContent -
+func TestCheckContent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rtimg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	table := []struct {
+		name     string
+		img      image.Image
+		truncate int
+		warnings int
+		isErr    bool
+	}{
+		{"ok.jpg", gradient(200, 100, false, 0), 0, 0, false},
+		{"black.jpg", image.NewGray(image.Rect(0, 0, 200, 100)), 0, 1, false},
+		{"truncated.jpg", gradient(200, 100, false, 0), 400, 0, true},
+	}
+	for _, v := range table {
+		path := filepath.Join(dir, v.name)
+		writeJPG(t, path, v.img, v.truncate)
+		findings, err := CheckContent(os.DirFS(dir), v.name, nil)
+		if (err != nil) != v.isErr {
+			t.Errorf("%v: CheckContent() error: %v", v.name, err)
+		}
+		if len(findings) != v.warnings {
+			t.Errorf("%v: CheckContent() findings: %v", v.name, findings)
+		}
+	}
+
+	path := filepath.Join(dir, "bad.psd")
+	if err := ioutil.WriteFile(path, []byte("not a psd"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CheckContent(os.DirFS(dir), "bad.psd", nil); err == nil {
+		t.Errorf("bad.psd: CheckContent() has no error")
+	}
+
+	// only the signature of a psd is read and other formats are not read at all
+	fsys := tSignatureFS{fstest.MapFS{
+		"ok.psd":    {Data: append([]byte("8BPS"), make([]byte, 1024)...)},
+		"short.psd": {Data: []byte("8B")},
+		"empty.psd": {},
+		"empty.txt": {},
+		"some.txt":  {Data: make([]byte, 1024)},
+	}}
+	for name, isErr := range map[string]bool{"ok.psd": false, "short.psd": true, "empty.psd": true, "empty.txt": false, "some.txt": false} {
+		if _, err := CheckContent(fsys, name, nil); (err != nil) != isErr {
+			t.Errorf("%v: CheckContent() error: %v", name, err)
+		}
+	}
+}
+
+// tSignatureFS - a file system that fails to read more than a signature of a file.
+type tSignatureFS struct {
+	fstest.MapFS
+}
+
+type tSignatureFile struct {
+	fs.File
+	n int
+}
+
+func (o tSignatureFS) Open(name string) (fs.File, error) {
+	if strings.HasSuffix(name, ".txt") {
+		return nil, fmt.Errorf("%v is read", name)
+	}
+	f, err := o.MapFS.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &tSignatureFile{File: f}, nil
+}
+
+func (o *tSignatureFile) Read(p []byte) (int, error) {
+	o.n += len(p)
+	if o.n > len(psdSignature) {
+		return 0, fmt.Errorf("more than a signature is read")
+	}
+	return o.File.Read(p)
+}
+
+// TestFindBorders -
+func TestFindBorders(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 192, 108))
+	for y := 12; y < 108-10; y++ {
+		for x := 0; x < 192; x++ {
+			img.Set(x, y, color.RGBA{uint8(x), uint8(y * 2), 200, 255})
+		}
+	}
+	borders := FindBorders(img, 4)
+	if len(borders) != 2 {
+		t.Fatalf("FindBorders() = %v, want top and bottom", borders)
+	}
+	if borders[0].Side != "top" || borders[0].Thickness != 12 || borders[1].Side != "bottom" || borders[1].Thickness != 10 {
+		t.Errorf("FindBorders() = %v", borders)
+	}
+	if s := borders[0].String(); s != "black border at top (12px)" {
+		t.Errorf("TBorder.String() = %q", s)
+	}
+	if borders := FindBorders(img, 16); len(borders) != 0 {
+		t.Errorf("FindBorders() = %v, want none", borders)
+	}
+
+	for path, severity := range map[string]string{
+		"some/project/logo.png": SeverityOff,
+		"some/project/google_apple_feed/jpg/g_hasLogo_600x600.png": SeverityOff,
+		"some/project/1920x1080.jpg":                               SeverityError,
+	} {
+		key, err := FindKey(path, nil)
+		if err != nil || severityOf(FindingBorder, key.Data()) != severity {
+			t.Errorf("%v: border severity must be %v (%v)", path, severity, err)
+		}
+	}
+}
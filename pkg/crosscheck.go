@@ -0,0 +1,89 @@
+package rtimg
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// name mismatch handling
+const (
+	CheckOff   = "off"
+	CheckWarn  = "warn"
+	CheckError = "error"
+)
+
+// NameMismatchCheck - what to do when the project name of a path <key> disagrees
+// with the tags of the file name.
+var NameMismatchCheck = CheckWarn
+
+// tags that are compared with the tokens of a project name
+var crossCheckTags = []struct {
+	tag string
+	re  *regexp.Regexp
+}{
+	{"sxx", regexp.MustCompile(`^s\d+$`)},
+	{"exx", regexp.MustCompile(`^e\d+$`)},
+	{"year", regexp.MustCompile(`^\d{4}$`)},
+	{"sdhd", regexp.MustCompile(`^(sd|hd|3d)$`)},
+}
+
+// CrossCheckName compares the project name of the <key> with the tags of the file
+// name (name, season, episode, year and sd/hd) and returns mismatches. Tags that are
+// absent on either side are not compared.
+func CrossCheckName(key *TKey, tn ITagname) []string {
+	if key == nil || tn == nil || tn.State() != nil {
+		return nil
+	}
+	tokens := strings.Split(strings.ToLower(normalizeName(key.Name())), "_")
+
+	ret := []string(nil)
+	// leading tokens that do not look like tags form the name
+	n := 0
+	for ; n < len(tokens); n++ {
+		if isTagToken(tokens[n]) {
+			break
+		}
+	}
+	dirName := strings.Join(tokens[:n], "_")
+	if name, _ := tn.GetTag("name"); name != "" && dirName != "" && !strings.EqualFold(normalizeName(name), dirName) {
+		ret = append(ret, fmt.Sprintf("name: %q in the file name, %q in the project name", name, dirName))
+	}
+
+	for _, v := range crossCheckTags {
+		val, _ := tn.GetTag(v.tag)
+		if val == "" {
+			continue
+		}
+		val = strings.ToLower(val)
+		found := []string{}
+		for _, token := range tokens[n:] {
+			if v.re.MatchString(token) {
+				found = append(found, token)
+			}
+		}
+		if len(found) == 0 || contains(found, val) {
+			continue
+		}
+		ret = append(ret, fmt.Sprintf("%v: %q in the file name, %q in the project name", v.tag, val, strings.Join(found, ",")))
+	}
+	return ret
+}
+
+func isTagToken(s string) bool {
+	for _, v := range crossCheckTags {
+		if v.re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
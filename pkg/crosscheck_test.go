@@ -0,0 +1,49 @@
+package rtimg
+
+import (
+	"fmt"
+	"testing"
+)
+
+type fakeTagname map[string]string
+
+func (o fakeTagname) GetTag(tag string) (string, error) {
+	if v, ok := o[tag]; ok {
+		return v, nil
+	}
+	return "", fmt.Errorf("tag %q not found", tag)
+}
+
+func (o fakeTagname) Source() string {
+	return o["source"]
+}
+
+func (o fakeTagname) State() error {
+	return nil
+}
+
+// TestCrossCheckName -
+func TestCrossCheckName(t *testing.T) {
+	table := []struct {
+		path       string
+		tags       fakeTagname
+		mismatches int
+	}{
+		{"x/The_name_s01_2018_hd/600x600.jpg", fakeTagname{"name": "The_name", "sxx": "s01", "year": "2018", "sdhd": "hd"}, 0},
+		{"x/The_name_s01_2018_hd/600x600.jpg", fakeTagname{"name": "The_name"}, 0},
+		{"x/The_name_2018/600x600.jpg", fakeTagname{"name": "The_name", "sxx": "s02"}, 0},
+		{"x/The_name_s01_2018_hd/600x600.jpg", fakeTagname{"name": "Other", "sxx": "s02", "year": "2019", "sdhd": "sd"}, 4},
+		{"x/The_name_s01_e03/600x600.jpg", fakeTagname{"name": "the_name", "exx": "e04"}, 1},
+	}
+	for _, v := range table {
+		key, err := FindKey(v.path, nil)
+		if err != nil {
+			t.Errorf("%v: FindKey() error: %v", v.path, err)
+			continue
+		}
+		list := CrossCheckName(key, v.tags)
+		if len(list) != v.mismatches {
+			t.Errorf("%v: CrossCheckName(%v) = %q", v.path, v.tags, list)
+		}
+	}
+}
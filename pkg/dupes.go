@@ -0,0 +1,167 @@
+package rtimg
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	// register decoders
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"io/fs"
+	"math/bits"
+	"sort"
+)
+
+type (
+	// TDupeEntry - a checked image that takes part in the duplicates search.
+	TDupeEntry struct {
+		Path       string
+		ProjectDir string
+		// <key> hash ("./1920x1080.jpg" for example)
+		Hash  string
+		PHash uint64
+		// SHA-256 of the file ("" - unknown)
+		Sum string
+	}
+	// TDupeGroup - images of the same <key> from different projects that look alike.
+	TDupeGroup struct {
+		Hash string
+		// the files are byte for byte the same
+		Identical bool
+		Entries   []TDupeEntry
+	}
+)
+
+// ImageHash computes a perceptual hash of the image name of the file system (see HashImage).
+// Near-uniform images (see CheckContent) all have alike hashes that say nothing
+// about duplicates, so ErrUniform is returned for them.
+func ImageHash(fsys fs.FS, name string) (uint64, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return 0, fmt.Errorf("decode: %v", err)
+	}
+	if _, stdDev, _ := lumaStats(img); stdDev < uniformStdDev {
+		return 0, ErrUniform
+	}
+	return HashImage(img), nil
+}
+
+// FileSum returns a hex SHA-256 of the file name of the file system.
+func FileSum(fsys fs.FS, name string) (string, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// HashImage computes a 64 bit difference hash (dHash): the image is reduced to
+// a 9x8 grayscale grid and every bit tells if a cell is brighter than its right neighbour.
+func HashImage(img image.Image) uint64 {
+	const w, h = 9, 8
+	grid := [h][w]float64{}
+	count := [h][w]int{}
+	rect := img.Bounds()
+	if rect.Empty() {
+		return 0
+	}
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		gy := (y - rect.Min.Y) * h / rect.Dy()
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			gx := (x - rect.Min.X) * w / rect.Dx()
+			r, g, b, _ := img.At(x, y).RGBA()
+			grid[gy][gx] += 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)
+			count[gy][gx]++
+		}
+	}
+	ret := uint64(0)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if count[y][x] > 0 {
+				grid[y][x] /= float64(count[y][x])
+			}
+		}
+		for x := 0; x < w-1; x++ {
+			ret <<= 1
+			if grid[y][x] > grid[y][x+1] {
+				ret |= 1
+			}
+		}
+	}
+	return ret
+}
+
+// HashDistance returns the number of differing bits of two perceptual hashes.
+func HashDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// FindDupes groups entries of the same <key> hash from different project
+// directories whose perceptual hashes differ by no more than maxDist bits.
+// A group is identical only if the files have the same SHA-256.
+func FindDupes(entries []TDupeEntry, maxDist int) []TDupeGroup {
+	parent := make([]int, len(entries))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	for i := range entries {
+		for j := i + 1; j < len(entries); j++ {
+			a, b := entries[i], entries[j]
+			if a.Hash != b.Hash || a.ProjectDir == b.ProjectDir {
+				continue
+			}
+			if HashDistance(a.PHash, b.PHash) > maxDist {
+				continue
+			}
+			parent[find(j)] = find(i)
+		}
+	}
+
+	groups := map[int][]TDupeEntry{}
+	for i, v := range entries {
+		root := find(i)
+		groups[root] = append(groups[root], v)
+	}
+	ret := []TDupeGroup{}
+	for _, list := range groups {
+		if len(list) < 2 {
+			continue
+		}
+		sort.Slice(list, func(i, j int) bool {
+			return list[i].Path < list[j].Path
+		})
+		identical := list[0].Sum != ""
+		for _, v := range list[1:] {
+			if v.Sum != list[0].Sum {
+				identical = false
+			}
+		}
+		ret = append(ret, TDupeGroup{Hash: list[0].Hash, Identical: identical, Entries: list})
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Hash != ret[j].Hash {
+			return ret[i].Hash < ret[j].Hash
+		}
+		return ret[i].Entries[0].Path < ret[j].Entries[0].Path
+	})
+	return ret
+}
@@ -0,0 +1,104 @@
+package rtimg
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+	"testing/fstest"
+)
+
+func gradient(w, h int, invert bool, noise uint8) image.Image {
+	img := image.NewGray(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			v := uint8(x * 255 / w)
+			if invert {
+				v = 255 - v
+			}
+			if (x+y)%7 == 0 && v < 255-noise {
+				v += noise
+			}
+			img.SetGray(x, y, color.Gray{v})
+		}
+	}
+	return img
+}
+
+// TestHashImage -
+func TestHashImage(t *testing.T) {
+	a := HashImage(gradient(360, 200, false, 0))
+	b := HashImage(gradient(180, 100, false, 3))
+	c := HashImage(gradient(360, 200, true, 0))
+	if d := HashDistance(a, b); d > 6 {
+		t.Errorf("similar images: distance %v is too big (%016x, %016x)", d, a, b)
+	}
+	if d := HashDistance(a, c); d < 32 {
+		t.Errorf("different images: distance %v is too small (%016x, %016x)", d, a, c)
+	}
+}
+
+// TestFindDupes -
+func TestFindDupes(t *testing.T) {
+	entries := []TDupeEntry{
+		{Path: "a/350x500.jpg", ProjectDir: "a", Hash: "./350x500.jpg", PHash: 0xff, Sum: "1"},
+		{Path: "b/350x500.jpg", ProjectDir: "b", Hash: "./350x500.jpg", PHash: 0xff, Sum: "1"},
+		{Path: "c/350x500.jpg", ProjectDir: "c", Hash: "./350x500.jpg", PHash: 0xfe},
+		{Path: "d/350x500.jpg", ProjectDir: "d", Hash: "./350x500.jpg", PHash: 0xff00},
+		// the same project
+		{Path: "a/1/600x600.jpg", ProjectDir: "a", Hash: "./600x600.jpg", PHash: 0x1},
+		{Path: "a/2/600x600.jpg", ProjectDir: "a", Hash: "./600x600.jpg", PHash: 0x1},
+		// different keys
+		{Path: "e/525x300.jpg", ProjectDir: "e", Hash: "./525x300.jpg", PHash: 0xff},
+	}
+	groups := FindDupes(entries, 2)
+	if len(groups) != 1 {
+		t.Fatalf("FindDupes() returns %v groups, want 1: %v", len(groups), groups)
+	}
+	if len(groups[0].Entries) != 3 || groups[0].Identical {
+		t.Errorf("FindDupes() returns wrong group: %v", groups[0])
+	}
+
+	groups = FindDupes(entries, 0)
+	if len(groups) != 1 || len(groups[0].Entries) != 2 || !groups[0].Identical {
+		t.Errorf("FindDupes() returns wrong groups: %v", groups)
+	}
+
+	// equal perceptual hashes of different files
+	entries[1].Sum = "2"
+	groups = FindDupes(entries, 0)
+	if len(groups) != 1 || groups[0].Identical {
+		t.Errorf("FindDupes() returns wrong groups: %v", groups)
+	}
+}
+
+// TestImageHash -
+func TestImageHash(t *testing.T) {
+	encode := func(img image.Image) []byte {
+		buf := &bytes.Buffer{}
+		if err := png.Encode(buf, img); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	fsys := fstest.MapFS{
+		"a.png":     {Data: encode(gradient(360, 200, false, 0))},
+		"b.png":     {Data: encode(gradient(360, 200, false, 0))},
+		"blank.png": {Data: encode(image.NewGray(image.Rect(0, 0, 360, 200)))},
+	}
+	if phash, err := ImageHash(fsys, "a.png"); err != nil || phash != HashImage(gradient(360, 200, false, 0)) {
+		t.Errorf("ImageHash(a.png) = %016x, %v", phash, err)
+	}
+	if _, err := ImageHash(fsys, "blank.png"); !errors.Is(err, ErrUniform) {
+		t.Errorf("ImageHash(blank.png) error: %v, want %v", err, ErrUniform)
+	}
+
+	a, errA := FileSum(fsys, "a.png")
+	b, errB := FileSum(fsys, "b.png")
+	blank, errBlank := FileSum(fsys, "blank.png")
+	if errA != nil || errB != nil || errBlank != nil || a != b || a == blank || len(a) != 64 {
+		t.Errorf("FileSum() = %q, %q, %q (%v, %v, %v)", a, b, blank, errA, errB, errBlank)
+	}
+}
@@ -0,0 +1,58 @@
+package rtimg
+
+import (
+	"errors"
+	"fmt"
+)
+
+// errors to test with errors.Is
+var (
+	ErrKeyNotFound          = errors.New("<key> not found")
+	ErrAmbiguousKey         = errors.New("ambiguous <key>")
+	ErrServiceDirectory     = errors.New("service directory cannot be a project name")
+	ErrTagnameMissing       = errors.New("tagname is missing")
+	ErrTooLarge             = errors.New("file is too large")
+	ErrNoOutput             = errors.New("no strategy has given an output")
+	ErrUniform              = errors.New("near-uniform image")
+	ErrUnsupportedExtension = errors.New("unsupported extension")
+	ErrToolFailure          = errors.New("external tool failed")
+)
+
+// TToolError - a failure of an external tool (ffmpeg, pngquant, exiftool). It is ErrToolFailure.
+type TToolError struct {
+	Tool   string
+	Output string
+	Err    error
+}
+
+func (o *TToolError) Error() string {
+	switch {
+	case o.Err == nil:
+		return fmt.Sprintf("%v: %q", o.Tool, o.Output)
+	case o.Output == "":
+		return fmt.Sprintf("%v: %v", o.Tool, o.Err)
+	}
+	return fmt.Sprintf("%v: %v: %q", o.Tool, o.Err, o.Output)
+}
+
+func (o *TToolError) Unwrap() error {
+	return o.Err
+}
+
+func (o *TToolError) Is(target error) bool {
+	return target == ErrToolFailure
+}
+
+// TSizeError - the file does not fit the size limit of its <key>. It is ErrTooLarge.
+type TSizeError struct {
+	Size  int64
+	Limit int64
+}
+
+func (o *TSizeError) Error() string {
+	return fmt.Sprintf("%v KB > %v KB", o.Size/kb, o.Limit/kb)
+}
+
+func (o *TSizeError) Is(target error) bool {
+	return target == ErrTooLarge
+}
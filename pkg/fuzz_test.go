@@ -0,0 +1,95 @@
+package rtimg
+
+import (
+	"path"
+	"strings"
+	"testing"
+)
+
+// odd paths and paths of every <key> of the table
+func keySeeds() []string {
+	ret := []string{
+		"", ".", "/", "//", "\\", "..", "../600x600.jpg", "./600x600.jpg/",
+		"600x600.jpg", "/600x600.jpg", "//a//b/600x600.jpg", "a/b/600x600.jpg/",
+		"C:\\x\\PROJECT\\600x600.jpg", "x\\\\PROJECT\\600x600.jpg", "x/PROJECT/1 сезон/2 серия/600x600.jpg",
+		"1 сезон/600x600.jpg", "/1 сезон/600x600.jpg", "x/для сервиса/для сервиса/600x600.jpg",
+		"x/PROJECT/google_apple_feed/jpg/g_hasLogo_600x600.png", "google_apple_feed/jpg/logo.png",
+		"x/PROJECT/600x600", "x/PROJECT/x600.jpg", "x/PROJECT/_600x600_.jpg",
+	}
+	for hash := range postersTable {
+		ret = append(ret, "x/PROJECT"+strings.TrimPrefix(hash, "."), strings.TrimPrefix(hash, "./"))
+	}
+	return ret
+}
+
+// checkKeyInvariants walks all levels of the <key> of the path and checks that at
+// every level the hash is a suffix of the path and the project directory plus the
+// hash reconstruct the path.
+func checkKeyInvariants(t *testing.T, p string) {
+	key, err := newKey(p, "")
+	if err != nil {
+		return
+	}
+	clean := normalizeHash(path.Clean(strings.ReplaceAll(p, "\\", "/")))
+	for do := true; do; do = key.NextLevel() {
+		hash := key.Hash()
+		if hash == "" {
+			t.Fatalf("%q: empty hash at level %v", p, key.level)
+		}
+		rel := strings.TrimPrefix(normalizeHash(hash), "./")
+		if !strings.HasSuffix("/"+clean, "/"+rel) {
+			t.Fatalf("%q: hash %q is not a suffix of the path", p, hash)
+		}
+		dir := key.ProjectDir()
+		got := rel
+		if len(key.segments)-1-key.level > 0 {
+			got = dir + "/" + rel
+		}
+		if normalizeHash(got) != clean {
+			t.Fatalf("%q: project dir %q plus hash %q is %q", p, dir, hash, got)
+		}
+		if dir != "" && key.Name() != path.Base(dir) {
+			t.Fatalf("%q: name %q, project dir %q", p, key.Name(), dir)
+		}
+		isDeclined(key)
+	}
+	if key.level != len(key.segments)-1 || key.NextLevel() {
+		t.Fatalf("%q: level %v after the last one of %v segments", p, key.level, len(key.segments))
+	}
+
+	key, _ = newKey(p, "")
+	level := key.level
+	key = doOffsetForProjectNameIfNeeded(key)
+	if key.level < level || key.level >= len(key.segments) {
+		t.Fatalf("%q: offset to level %v of %v segments", p, key.level, len(key.segments))
+	}
+	if (key.Season() >= 0 || key.Episode() >= 0) && key.level == level {
+		t.Fatalf("%q: season %v, episode %v without an offset", p, key.Season(), key.Episode())
+	}
+}
+
+// FuzzKey -
+func FuzzKey(f *testing.F) {
+	for _, v := range keySeeds() {
+		f.Add(v)
+	}
+	f.Fuzz(func(t *testing.T, p string) {
+		checkKeyInvariants(t, p)
+
+		key, err := FindKey(p, nil)
+		if err != nil {
+			return
+		}
+		// the data belongs to the current level or, after an offset, to an upper one
+		found := false
+		for k := *key; k.level >= 0 && !found; k.level-- {
+			found = key.Data() != nil && postersTable[k.Hash()] == key.Data()
+		}
+		if !found {
+			t.Fatalf("%q: hash %q, data %v is not in the table", p, key.Hash(), key.Data())
+		}
+		if name := key.Name(); isSubtreeDir(name) || (name == "") != (key.ProjectDir() == "") {
+			t.Fatalf("%q: project name %q, project dir %q", p, name, key.ProjectDir())
+		}
+	})
+}
@@ -1,8 +1,10 @@
 package rtimg
 
 import (
+	"errors"
 	"fmt"
-	// "os"
+	"io/fs"
+	"os"
 	"path/filepath"
 	"regexp"
 	"strings"
@@ -23,10 +25,37 @@ type (
 		level    int
 		segments []string
 		data     *TKeyData
+		// numbers from structural directories (-1 if there are none)
+		season  int
+		episode int
 	}
 	TKeyData struct {
 		Type          string
 		FileSizeLimit int64
+		// a set of <key>s that can be turned on and off as a whole (see SetProfiles)
+		Profile string
+		// kind of a finding -> severity, overrides the profile ones (see severityOf)
+		Severity map[string]string
+		// what the JPEG optimizer must not use (see ReduceJPG)
+		JPEG TJPEGConstraints
+		// what PNG strategies may do (see ReducePNG)
+		PNG TPNGConstraints
+		// safe zones and UI overlays (see ZonesFor)
+		Zones []TZone
+	}
+	// TCheckResult - a file with its <key> found and content checked.
+	TCheckResult struct {
+		Key        *TKey
+		Hash       string
+		Name       string
+		ProjectDir string
+		Type       string
+		// size limit in bytes (-1 - no limit)
+		Limit int64
+		// messages of warning findings
+		Warnings []string
+		// all findings (info and warning) with their severities
+		Findings []TFinding
 	}
 )
 
@@ -39,93 +68,36 @@ const (
 
 var validExtension = map[string]bool{}
 
-var cannotBeProjectName = []string{
-	"./для сервиса/",
-}
+// prefixes of subtree <key>s (see subtrees)
+var cannotBeProjectName = []string{}
 
-var doOffsetForProjectName = []*regexp.Regexp{
-	regexp.MustCompile(`^(.*/)?\d+ сезон$`),
-}
-
-var postersTable = map[string]*TKeyData{
-	"./350x500.jpg":  {"rt", 900 * kb},
-	"./350x500.psd":  {"rt", none},
-	"./525x300.jpg":  {"rt", 900 * kb},
-	"./525x300.psd":  {"rt", none},
-	"./810x498.jpg":  {"rt", 900 * kb},
-	"./810x498.psd":  {"rt", none},
-	"./270x390.jpg":  {"rt", 900 * kb},
-	"./270x390.psd":  {"rt", none},
-	"./1620x996.jpg": {"rt", 900 * kb},
-	"./1620x996.psd": {"rt", none},
-	// "./503x726.jpg":  {"rt", 900 * kb},
-	// "./503x726.psd":  {"rt", none},
-	"./1006x1452.jpg":  {"rt", 900 * kb},
-	"./1006x1452.psd":  {"rt", none},
-	"./logo.png":     {"rt", 900 * kb},
-	"./logo.psd":     {"rt", none},
-
-	// GP
-	"./600x600.jpg":          {"gp", 700 * kb},
-	"./600x600.psd":          {"gp", none},
-	"./600x840.jpg":          {"gp", 700 * kb},
-	"./600x840.psd":          {"gp", none},
-	"./1920x1080.jpg":        {"gp", 700 * kb},
-	"./1920x1080.psd":        {"gp", none},
-	"./1920x1080_left.jpg":   {"gp", 700 * kb},
-	"./1920x1080_left.psd":   {"gp", none},
-	"./1920x1080_center.jpg": {"gp", 700 * kb},
-	"./1920x1080_center.psd": {"gp", none},
-	"./1260x400.jpg":         {"gp", 700 * kb},
-	"./1260x400.psd":         {"gp", none},
-	"./1080x540.jpg":         {"gp", 700 * kb},
-	"./1080x540.psd":         {"gp", none},
-	// megafon
-	"./1080x810.png":  {"gp", 6 * mb},
-	"./1080x810.psd":  {"gp", none},
-	"./1080x1232.png": {"gp", 6 * mb},
-	"./1080x1232.psd": {"gp", none},
-	"./1104x624.png":  {"gp", 6 * mb},
-	"./1104x624.psd":  {"gp", none},
-	"./3840x1344.png": {"gp", 6 * mb},
-	"./3840x1344.psd": {"gp", none},
-	// viasat
-	"./для сервиса/600x600.jpg":   {"gp", 3 * mb},
-	"./для сервиса/600x600.psd":   {"gp", none},
-	"./для сервиса/600x840.jpg":   {"gp", 3 * mb},
-	"./для сервиса/600x840.psd":   {"gp", none},
-	"./для сервиса/1080x540.jpg":  {"gp", 3 * mb},
-	"./для сервиса/1080x540.psd":  {"gp", none},
-	"./для сервиса/1920x1080.jpg": {"gp", 3 * mb},
-	"./для сервиса/1920x1080.psd": {"gp", none},
-	"./для сервиса/1760x557.jpg":  {"gp", 3 * mb},
-	"./для сервиса/1760x557.psd":  {"gp", none},
-	// --
-	"./google_apple_feed/jpg/g_hasLogo_600x600.png":         {"gp", none},
-	"./google_apple_feed/psd/g_hasLogo_600x600.psd":         {"gp", none},
-	"./google_apple_feed/jpg/g_hasTitle_logo_1800x1000.png": {"gp", none},
-	"./google_apple_feed/psd/g_hasTitle_logo_1800x1000.psd": {"gp", none},
-
-	"./google_apple_feed/jpg/g_iconic_poster_600x600.jpg":       {"gp", 3 * mb},
-	"./google_apple_feed/psd/g_iconic_poster_600x600.psd":       {"gp", none},
-	"./google_apple_feed/jpg/g_iconic_poster_600x800.jpg":       {"gp", 3 * mb},
-	"./google_apple_feed/psd/g_iconic_poster_600x800.psd":       {"gp", none},
-	"./google_apple_feed/jpg/g_iconic_poster_800x600.jpg":       {"gp", 3 * mb},
-	"./google_apple_feed/psd/g_iconic_poster_800x600.psd":       {"gp", none},
-	"./google_apple_feed/jpg/g_iconic_poster_1000x1500.jpg":     {"gp", 3 * mb},
-	"./google_apple_feed/psd/g_iconic_poster_1000x1500.psd":     {"gp", none},
-	"./google_apple_feed/jpg/g_iconic_poster_3840x2160.jpg":     {"gp", 3 * mb},
-	"./google_apple_feed/psd/g_iconic_poster_3840x2160.psd":     {"gp", none},
-	"./google_apple_feed/jpg/g_iconic_background_1000x1500.jpg": {"gp", 3 * mb},
-	"./google_apple_feed/psd/g_iconic_background_1000x1500.psd": {"gp", none},
-	"./google_apple_feed/jpg/g_iconic_background_3840x2160.jpg": {"gp", 3 * mb},
-	"./google_apple_feed/psd/g_iconic_background_3840x2160.psd": {"gp", none},
-}
+// postersTable is ruleTemplates and subtree rules expanded (see rebuildTable)
+var postersTable = map[string]*TKeyData{}
 
 var reSize = regexp.MustCompile(`^(?:.*_)?(?:(\d+x\d+)|(logo))[\._].*$`)
 
 func init() {
+	rebuildTable()
+}
+
+// rebuildTable makes postersTable from rule templates and subtrees.
+func rebuildTable() {
+	list := append([]TRuleTemplate{}, ruleTemplates...)
+	prefixes := []string{}
+	for _, st := range subtrees {
+		prefixes = append(prefixes, "./"+st.Dir+"/")
+		list = append(list, st.templates()...)
+	}
+	postersTable = expandTemplates(list)
+	cannotBeProjectName = prefixes
+
+	normalizedHashes = map[string]string{}
+	for k := range postersTable {
+		normalizedHashes[normalizeHash(k)] = k
+	}
+
 	// gather valid extensions
+	validExtension = map[string]bool{}
 	for v := range postersTable {
 		ext := filepath.Ext(v)
 		validExtension[ext] = true
@@ -133,7 +105,28 @@ func init() {
 	// fmt.Printf("debug: valid extensions: %v\n", validExtension)
 }
 
-func CheckImage(filePath string, tn ITagname) (*TKeyData, error) {
+// DirFS returns the file system of the directory of the file on disk (os.DirFS)
+// and the name of the file in it. It is the file system of the functions that read
+// files by a path.
+func DirFS(filePath string) (fs.FS, string) {
+	dir, name := filepath.Split(filePath)
+	if dir == "" {
+		dir = "."
+	}
+	return os.DirFS(dir), name
+}
+
+// CheckImage finds a <key> for the file and, if filePath is not empty, checks the
+// image content.
+func CheckImage(filePath string, tn ITagname) (*TCheckResult, error) {
+	fsys, name := DirFS(filePath)
+	return CheckImageFS(fsys, name, filePath, tn)
+}
+
+// CheckImageFS is CheckImage for the file name of the file system (a directory on
+// disk, an archive, an in-memory tree). filePath is the path to find a <key> by, so
+// it must contain the project directory (an archive entry has a virtual one).
+func CheckImageFS(fsys fs.FS, name, filePath string, tn ITagname) (*TCheckResult, error) {
 	key, err := FindKey(filePath, tn)
 	if err != nil {
 		return nil, err
@@ -142,7 +135,39 @@ func CheckImage(filePath string, tn ITagname) (*TKeyData, error) {
 	if data == nil {
 		return nil, fmt.Errorf("unreachable: something wrong with a <key>")
 	}
-	return data, nil
+	findings := []TFinding(nil)
+	if key.name == "" && NameMismatchCheck != CheckOff {
+		for _, v := range CrossCheckName(key, tn) {
+			findings = append(findings, TFinding{Kind: FindingNameMismatch, Message: v})
+		}
+	}
+	contentFindings := []TFinding(nil)
+	if name != "" {
+		contentFindings, err = CheckContent(fsys, name, key)
+		if err != nil {
+			return nil, err
+		}
+	}
+	findings, err = resolveFindings(append(findings, contentFindings...), data)
+	if err != nil {
+		return nil, err
+	}
+	warnings := []string(nil)
+	for _, v := range findings {
+		if v.Severity == SeverityWarning {
+			warnings = append(warnings, v.Message)
+		}
+	}
+	return &TCheckResult{
+		Key:        key,
+		Hash:       key.Hash(),
+		Name:       key.Name(),
+		ProjectDir: key.ProjectDir(),
+		Type:       data.Type,
+		Limit:      data.FileSizeLimit,
+		Warnings:   warnings,
+		Findings:   findings,
+	}, nil
 }
 
 func GetProjectDir(filePath string) string {
@@ -153,48 +178,77 @@ func GetProjectDir(filePath string) string {
 	return key.ProjectDir()
 }
 
-// FindKey - tagname will be used only if it failed to find <key> for the path
+// FindKey - tagname will be used only if it failed to find <key> for the path,
+// otherwise it must not give another <key> (ErrAmbiguousKey)
 func FindKey(path string, tn ITagname) (*TKey, error) {
 	name := ""
 	key, err := tryToFindKey(path, name)
-	if err != nil {
-		if tn == nil {
-			return nil, fmt.Errorf("findKey: tagname is <nil>")
-		}
-		// check if the pointer over interface is nil
-		if tn.State() != nil {
-			return nil, fmt.Errorf("findKey: <key> not found")
-		}
-		path, err = pathFromTagname(tn)
-		if err != nil {
-			return nil, fmt.Errorf("findKey: %v", err)
-		}
-		name, err = makeNameUsingTags(tn)
-		if err != nil {
-			return nil, fmt.Errorf("findKey: %v", err)
+	if err == nil {
+		// a tagname that gives another <key> makes the path one ambiguous (project
+		// names that disagree are left to CrossCheckName)
+		if tagKey, err := findKeyUsingTags(tn); err == nil && tagKey.Hash() != key.Hash() {
+			return nil, fmt.Errorf("findKey: %w: %v by path, %v by tagname", ErrAmbiguousKey, key.Hash(), tagKey.Hash())
 		}
-		key, err = tryToFindKey(path, name)
+	}
+	if err != nil {
+		pathErr := err
+		key, err = findKeyUsingTags(tn)
 		if err != nil {
-			return nil, fmt.Errorf("findKey: %v", err)
+			// a path that matches only inactive profiles is a more useful reason
+			var profileErr *tProfileError
+			if errors.As(pathErr, &profileErr) {
+				return nil, fmt.Errorf("findKey: %w", pathErr)
+			}
+			// without a tagname the path is the only source of a <key>
+			if errors.Is(err, ErrTagnameMissing) && path != "" {
+				err = fmt.Errorf("findKey: %w", pathErr)
+			}
+			if list := Suggest(path, 1); len(list) > 0 {
+				return nil, fmt.Errorf("%w; %v", err, list[0])
+			}
+			return nil, err
 		}
 	}
 	key = doOffsetForProjectNameIfNeeded(key)
 	return key, nil
 }
 
+func findKeyUsingTags(tn ITagname) (*TKey, error) {
+	if tn == nil {
+		return nil, fmt.Errorf("findKey: %w", ErrTagnameMissing)
+	}
+	// check if the pointer over interface is nil
+	if err := tn.State(); err != nil {
+		return nil, fmt.Errorf("findKey: %w: %v", ErrTagnameMissing, err)
+	}
+	path, err := pathFromTagname(tn)
+	if err != nil {
+		return nil, fmt.Errorf("findKey: %w: %v", ErrTagnameMissing, err)
+	}
+	name, err := makeNameUsingTags(tn)
+	if err != nil {
+		return nil, fmt.Errorf("findKey: %w: %v", ErrTagnameMissing, err)
+	}
+	key, err := tryToFindKey(path, name)
+	if err != nil {
+		return nil, fmt.Errorf("findKey: %w", err)
+	}
+	return key, nil
+}
+
 func newKey(path string, name string) (*TKey, error) {
-	p := filepath.Clean(path)
 	// ### TODO ###: seems to be a dirty hack
-	p = strings.ReplaceAll(p, "\\", "/")
+	// cleaned after the replacement, otherwise doubled backslashes give empty segments
+	p := filepath.ToSlash(filepath.Clean(strings.ReplaceAll(path, "\\", "/")))
 
 	segments := strings.Split(p, "/")
 
-	list := reSize.FindAllString(segments[len(segments)-1], -1)
+	list := reSize.FindAllString(normalizeHash(segments[len(segments)-1]), -1)
 	if len(list) != 1 {
-		return nil, fmt.Errorf("newKey: something wrong with a size tag")
+		return nil, fmt.Errorf("newKey: %w: something wrong with a size tag", ErrKeyNotFound)
 	}
 	size := list[0]
-	return &TKey{segments: segments, name: name, size: size}, nil
+	return &TKey{segments: segments, name: name, size: size, season: -1, episode: -1}, nil
 }
 
 func (o *TKey) Len() int {
@@ -208,6 +262,8 @@ func (o *TKey) Segment(n int) (string, bool) {
 	return o.segments[n], true
 }
 
+// Hash returns the <key> hash of the current level. It is normalized (see
+// canonicalHash), so it may differ from the path segments in case or unicode form.
 func (o *TKey) Hash() string {
 	if o.level < 0 || o.level >= len(o.segments) {
 		return ""
@@ -215,11 +271,13 @@ func (o *TKey) Hash() string {
 	idx := len(o.segments) - 1 - o.level
 	ret := strings.Join(o.segments[idx:], "/")
 	// fmt.Println("debug: ", ret)
-	return "./" + ret
+	return canonicalHash("./" + ret)
 }
 
+// NextLevel moves to the next (one directory deeper) level. It returns false if
+// the current level is the last one.
 func (o *TKey) NextLevel() bool {
-	if o.Hash() == "" {
+	if o.level < 0 || o.level+1 >= len(o.segments) {
 		return false
 	}
 	o.level++
@@ -246,6 +304,16 @@ func (o *TKey) Data() *TKeyData {
 	*/
 }
 
+// Season returns a number from the season directory or -1.
+func (o *TKey) Season() int {
+	return o.season
+}
+
+// Episode returns a number from the episode directory or -1.
+func (o *TKey) Episode() int {
+	return o.episode
+}
+
 func (o *TKey) Size() string {
 	return o.size
 }
@@ -303,20 +371,32 @@ func pathFromTagname(tn ITagname) (string, error) {
 	return ret, nil
 }
 
+// doOffsetForProjectNameIfNeeded moves structural directories (seasons, episodes,
+// extras) from the project directory to the <key> and remembers their numbers.
 func doOffsetForProjectNameIfNeeded(key *TKey) *TKey {
-	projectDir := key.ProjectDir()
-	for _, re := range doOffsetForProjectName {
-		if re.MatchString(projectDir) {
-			key.level++
+	for {
+		dir, ok := key.Segment(len(key.segments) - 2 - key.level)
+		if !ok {
+			return key
+		}
+		rule, num := matchStructure(dir)
+		if rule == nil {
+			return key
+		}
+		switch {
+		case rule.Kind == KindSeason && key.season < 0:
+			key.season = num
+		case rule.Kind == KindEpisode && key.episode < 0:
+			key.episode = num
 		}
+		key.level++
 	}
-	return key
 }
 
 func isDeclined(key *TKey) bool {
 	path := strings.TrimPrefix(key.Hash(), "./")
 	for _, prefix := range cannotBeProjectName {
-		hash := prefix + path
+		hash := canonicalHash(prefix + path)
 		if _, ok := postersTable[hash]; ok {
 			return true
 		}
@@ -330,14 +410,18 @@ func tryToFindKey(path string, name string) (*TKey, error) {
 		return nil, err
 	}
 	var declinedKey *TKey
+	otherProfiles := []string{}
 	for do := true; do; do = key.NextLevel() {
-		data, ok := postersTable[key.Hash()]
-		if !ok {
+		data, profile := lookupKey(key.Hash())
+		if profile != "" && !contains(otherProfiles, profile) {
+			otherProfiles = append(otherProfiles, profile)
+		}
+		if data == nil {
 			continue
 		}
 		key.data = data
 
-		if isDeclined(key) {
+		if isDeclined(key) || isSubtreeDir(key.Name()) {
 			if declinedKey == nil {
 				declinedKey = &TKey{}
 			}
@@ -347,9 +431,21 @@ func tryToFindKey(path string, name string) (*TKey, error) {
 		}
 	}
 
-	if declinedKey != nil {
+	if declinedKey != nil && !isSubtreeDir(declinedKey.Name()) {
 		return declinedKey, nil
 	}
+	if len(otherProfiles) > 0 {
+		return nil, &tProfileError{profiles: otherProfiles}
+	}
+	if declinedKey != nil {
+		return nil, fmt.Errorf("tryToFindKey(): %w: %q %v", ErrServiceDirectory, declinedKey.Name(), declinedKey)
+	}
+
+	return nil, fmt.Errorf("tryToFindKey(): %w %v", ErrKeyNotFound, key)
+}
 
-	return nil, fmt.Errorf("tryToFindKey(): <key> not found %v", key)
+// IsValidExtension reports whether there is at least one <key> with the extension
+// (case-insensitive, aliases included).
+func IsValidExtension(ext string) bool {
+	return validExtension[NormalizeExt(ext)]
 }
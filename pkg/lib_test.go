@@ -0,0 +1,443 @@
+package rtimg
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// TestStructure -
+func TestStructure(t *testing.T) {
+	table := []struct {
+		path            string
+		projectDir      string
+		hash            string
+		season, episode int
+	}{
+		{"x/PROJECT/600x600.jpg", "x/PROJECT", "./600x600.jpg", -1, -1},
+		{"x/PROJECT/1 сезон/600x600.jpg", "x/PROJECT", "./1 сезон/600x600.jpg", 1, -1},
+		{"x/PROJECT/Season 02/Episode 3/600x600.jpg", "x/PROJECT", "./Season 02/Episode 3/600x600.jpg", 2, 3},
+		{"x/PROJECT/S01/extras/600x600.jpg", "x/PROJECT", "./S01/extras/600x600.jpg", 1, -1},
+		{"x/PROJECT/Staffel 4/600x600.jpg", "x/PROJECT", "./Staffel 4/600x600.jpg", 4, -1},
+		{"x/PROJECT/сезон 2/3 серия/для сервиса/600x600.jpg", "x/PROJECT", "./сезон 2/3 серия/для сервиса/600x600.jpg", 2, 3},
+	}
+	for _, v := range table {
+		key, err := FindKey(v.path, nil)
+		if err != nil {
+			t.Errorf("%v: FindKey() error: %v", v.path, err)
+			continue
+		}
+		if key.ProjectDir() != v.projectDir || key.Hash() != v.hash {
+			t.Errorf("%v: project dir %q, hash %q", v.path, key.ProjectDir(), key.Hash())
+		}
+		if key.Season() != v.season || key.Episode() != v.episode {
+			t.Errorf("%v: season %v, episode %v", v.path, key.Season(), key.Episode())
+		}
+	}
+}
+
+// TestConfigStructure -
+func TestConfigStructure(t *testing.T) {
+	defer func(rules []TStructureRule) { structureRules = rules }(structureRules)
+
+	config := &TConfig{Structure: []TStructureRule{{Kind: KindSeason, Pattern: `^Книга (\d+)$`}}}
+	if err := config.Apply(); err != nil {
+		t.Fatal(err)
+	}
+	key, err := FindKey("x/PROJECT/Книга 5/600x600.jpg", nil)
+	if err != nil || key.ProjectDir() != "x/PROJECT" || key.Season() != 5 {
+		t.Errorf("FindKey() = %v, %v", key, err)
+	}
+	key, err = FindKey("x/PROJECT/1 сезон/600x600.jpg", nil)
+	if err != nil || key.ProjectDir() != "x/PROJECT/1 сезон" {
+		t.Errorf("FindKey() = %v, %v", key, err)
+	}
+
+	config = &TConfig{Structure: []TStructureRule{{Kind: KindEpisode, Pattern: `^ep$`}}}
+	if err := config.Apply(); err == nil {
+		t.Errorf("Apply() must fail on a pattern without a group")
+	}
+}
+
+// TestSubtrees -
+func TestSubtrees(t *testing.T) {
+	defer func(list []TSubtree) {
+		subtrees = list
+		rebuildTable()
+	}(subtrees)
+
+	key, err := FindKey("x/PROJECT/для сервиса/1760x557.jpg", nil)
+	if err != nil || key.Name() != "PROJECT" || key.Data().FileSizeLimit != 3*mb {
+		t.Errorf("FindKey() = %v, %v", key, err)
+	}
+	// a subtree without such a <key>
+	if key, err := FindKey("x/PROJECT/для сервиса/350x500.jpg", nil); err == nil {
+		t.Errorf("FindKey() = %v, must fail", key)
+	}
+
+	config := &TConfig{Subtrees: []TSubtree{
+		{Name: "partner", Dir: "partner", Rules: []TRuleTemplate{
+			{Type: "partner", Sizes: []string{"350x500"}, Exts: map[string]int64{".jpg": 2 * mb},
+				Severity: map[string]string{FindingUniform: SeverityError}},
+			{Type: "partner", Prefix: "hd/", Sizes: []string{"1920x1080"}, Exts: map[string]int64{".jpg": 5 * mb}},
+		}},
+	}}
+	if err := config.Apply(); err != nil {
+		t.Fatal(err)
+	}
+	key, err = FindKey("x/PROJECT/partner/350x500.jpg", nil)
+	if err != nil || key.Name() != "PROJECT" || key.Data().Type != "partner" || key.Data().Profile != "partner" ||
+		key.Data().Severity[FindingUniform] != SeverityError {
+		t.Errorf("FindKey() = %v, %v", key, err)
+	}
+	key, err = FindKey("x/PROJECT/partner/hd/1920x1080.jpg", nil)
+	if err != nil || key.Name() != "PROJECT" || key.Data().FileSizeLimit != 5*mb {
+		t.Errorf("FindKey() = %v, %v", key, err)
+	}
+	if _, ok := postersTable["./для сервиса/600x600.jpg"]; !ok {
+		t.Errorf("built-in subtree was dropped")
+	}
+
+	for _, v := range []TSubtree{
+		{Name: "bad", Dir: "a/b", Rules: []TRuleTemplate{{Type: "gp", Sizes: []string{"1x1"}, Exts: map[string]int64{".jpg": none}}}},
+		{Name: "bad", Dir: "bad"},
+		{Name: "bad", Dir: "bad", Rules: []TRuleTemplate{{Type: "gp", Sizes: []string{"cover"}, Exts: map[string]int64{".jpg": none}}}},
+	} {
+		config = &TConfig{Subtrees: []TSubtree{v}}
+		if err := config.Apply(); err == nil {
+			t.Errorf("Apply(%v) must fail", v)
+		}
+	}
+}
+
+// TestProfiles -
+func TestProfiles(t *testing.T) {
+	defer SetProfiles(nil)
+
+	if err := SetProfiles([]string{"rt", "nonexistent"}); err == nil {
+		t.Errorf("SetProfiles() must fail on an unknown profile")
+	}
+	if err := SetProfiles([]string{"gp"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := FindKey("x/PROJECT/600x600.jpg", nil); err != nil {
+		t.Errorf("FindKey() error: %v", err)
+	}
+	_, err := FindKey("x/PROJECT/350x500.jpg", nil)
+	if err == nil || !strings.Contains(err.Error(), "inactive profile(s) rt") {
+		t.Errorf("FindKey() error: %v", err)
+	}
+	_, err = FindKey("x/PROJECT/для сервиса/600x600.jpg", nil)
+	if err == nil || !strings.Contains(err.Error(), "inactive profile(s) viasat") {
+		t.Errorf("FindKey() error: %v", err)
+	}
+	if list := MissingKeys([]string{"./600x600.jpg"}); contains(list, "./350x500.jpg") || !contains(list, "./600x840.jpg") {
+		t.Errorf("MissingKeys() = %v", list)
+	}
+}
+
+// TestRules -
+func TestRules(t *testing.T) {
+	defer func(list []TRuleTemplate) {
+		ruleTemplates = list
+		rebuildTable()
+	}(ruleTemplates)
+
+	if err := checkTemplates(defaultRuleTemplates); err != nil {
+		t.Fatal(err)
+	}
+	table := expandTemplates([]TRuleTemplate{
+		{Profile: "p", Type: "t", Prefix: "sub/", Sizes: []string{"100x200", "logo"}, Exts: map[string]int64{".jpg": kb, ".psd": none}},
+	})
+	if len(table) != 4 || table["./sub/logo.psd"] == nil || table["./sub/100x200.jpg"].FileSizeLimit != kb {
+		t.Errorf("expandTemplates() = %v", table)
+	}
+
+	config := &TConfig{Rules: []TRuleTemplate{
+		{Profile: "partner", Type: "gp", Sizes: []string{"350x500", "2000x3000"}, Exts: map[string]int64{".jpg": 5 * mb},
+			JPEG: TJPEGConstraints{Baseline: true}},
+	}}
+	if err := config.Apply(); err != nil {
+		t.Fatal(err)
+	}
+	key, err := FindKey("x/PROJECT/2000x3000.jpg", nil)
+	if err != nil || key.Data().Profile != "partner" || !key.Data().JPEG.Baseline {
+		t.Errorf("FindKey() = %v, %v", key, err)
+	}
+	// overridden
+	if data := postersTable["./350x500.jpg"]; data.Profile != "partner" {
+		t.Errorf("./350x500.jpg = %v", data)
+	}
+	if data := postersTable["./350x500.psd"]; data.Profile != "rt" {
+		t.Errorf("./350x500.psd = %v", data)
+	}
+
+	rules := Rules()
+	if len(rules) != len(postersTable) {
+		t.Errorf("Rules() returned %v of %v", len(rules), len(postersTable))
+	}
+	for i := 1; i < len(rules); i++ {
+		if rules[i-1].Profile > rules[i].Profile {
+			t.Errorf("Rules() is not sorted: %v before %v", rules[i-1], rules[i])
+		}
+	}
+
+	for _, v := range []TRuleTemplate{
+		{Type: "gp", Sizes: []string{"1x1"}, Exts: map[string]int64{".jpg": none}},
+		{Profile: "p", Type: "gp", Sizes: []string{"cover"}, Exts: map[string]int64{".jpg": none}},
+		{Profile: "p", Type: "gp", Sizes: []string{"1x1"}, Exts: map[string]int64{"jpg": none}},
+		{Profile: "p", Type: "gp", Prefix: "sub", Sizes: []string{"1x1"}, Exts: map[string]int64{".jpg": none}},
+	} {
+		config := &TConfig{Rules: []TRuleTemplate{v}}
+		if err := config.Apply(); err == nil {
+			t.Errorf("Apply(%v) must fail", v)
+		}
+	}
+}
+
+// TestNormalize -
+func TestNormalize(t *testing.T) {
+	defer func(list []TSubtree, aliases map[string]string) {
+		subtrees = list
+		extAliases = aliases
+		rebuildTable()
+	}(subtrees, extAliases)
+
+	// "й" composed and decomposed (as macOS clients store it)
+	nfc, nfd := "мой сервис", "мои\u0306 сервис"
+	config := &TConfig{Subtrees: []TSubtree{
+		{Name: "partner", Dir: nfc, Rules: []TRuleTemplate{{Type: "gp", Sizes: []string{"600x600"}, Exts: map[string]int64{".jpg": mb}}}},
+	}}
+	if err := config.Apply(); err != nil {
+		t.Fatal(err)
+	}
+
+	type tCase struct {
+		path, hash, projectDir string
+	}
+	for _, v := range []tCase{
+		{"x/PROJECT/1920X1080.JPG", "./1920x1080.jpg", "x/PROJECT"},
+		{"x/PROJECT/350x500.jpeg", "./350x500.jpg", "x/PROJECT"},
+		{"x/PROJECT/google_apple_feed/jpg/G_HASLOGO_600x600.Png", "./google_apple_feed/jpg/g_hasLogo_600x600.png", "x/PROJECT"},
+		{"x/PROJECT/" + nfd + "/600x600.jpg", "./" + nfc + "/600x600.jpg", "x/PROJECT"},
+		{"x/" + nfd + "/1 сезон/600x600.jpg", "./1 сезон/600x600.jpg", "x/" + nfd},
+	} {
+		key, err := FindKey(v.path, nil)
+		if err != nil {
+			t.Errorf("FindKey(%q) error: %v", v.path, err)
+			continue
+		}
+		if key.Hash() != v.hash || key.ProjectDir() != v.projectDir {
+			t.Errorf("FindKey(%q) = %q, %q, want %q, %q", v.path, key.Hash(), key.ProjectDir(), v.hash, v.projectDir)
+		}
+	}
+	if !IsValidExtension(".JPEG") || IsValidExtension(".jfif") {
+		t.Errorf("IsValidExtension() is wrong")
+	}
+
+	config = &TConfig{ExtAliases: map[string]string{".JFIF": ".jpg"}}
+	if err := config.Apply(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := FindKey("x/PROJECT/600x600.jfif", nil); err != nil || !IsValidExtension(".jfif") {
+		t.Errorf("FindKey() error: %v", err)
+	}
+	for _, aliases := range []map[string]string{{"jfif": ".jpg"}, {".jpg": ".jpg"}, {".x": ".jpeg"}} {
+		config = &TConfig{ExtAliases: aliases}
+		if err := config.Apply(); err == nil {
+			t.Errorf("Apply(%v) must fail", aliases)
+		}
+	}
+}
+
+// TestErrors -
+func TestErrors(t *testing.T) {
+	defer SetProfiles(nil)
+
+	type tCase struct {
+		path string
+		want error
+	}
+	for _, v := range []tCase{
+		{"x/PROJECT/810x489.jpg", ErrKeyNotFound},
+		{"x/PROJECT/poster.jpg", ErrKeyNotFound},
+		{"", ErrTagnameMissing},
+		{"x/для сервиса/для сервиса/600x600.jpg", ErrServiceDirectory},
+	} {
+		_, err := CheckImage(v.path, nil)
+		if !errors.Is(err, v.want) {
+			t.Errorf("CheckImage(%q) error: %v, want %v", v.path, err, v.want)
+		}
+	}
+	// the path and the tagname give different <key>s
+	path := "x/PROJECT/600x600.jpg"
+	if _, err := FindKey(path, fakeTagname{"source": path, "name": "PROJECT", "sizetag": "600x840"}); !errors.Is(err, ErrAmbiguousKey) {
+		t.Errorf("FindKey() error: %v, want %v", err, ErrAmbiguousKey)
+	}
+	if key, err := FindKey(path, fakeTagname{"source": path, "name": "other", "sizetag": "600x600"}); err != nil || key.Name() != "PROJECT" {
+		t.Errorf("FindKey() = %v, %v, want the path <key>", key, err)
+	}
+
+	if err := SetProfiles([]string{"gp"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := FindKey("x/PROJECT/350x500.jpg", nil); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("FindKey() error: %v", err)
+	}
+
+	err := fmt.Errorf("cannot reduce file size: %w", &TSizeError{Size: 2 * mb, Limit: mb})
+	var sizeErr *TSizeError
+	if !errors.Is(err, ErrTooLarge) || !errors.As(err, &sizeErr) || sizeErr.Limit != mb {
+		t.Errorf("%v is not ErrTooLarge", err)
+	}
+	err = &TToolError{Tool: "ffmpeg", Err: exec.ErrNotFound}
+	if !errors.Is(err, ErrToolFailure) || !errors.Is(err, exec.ErrNotFound) {
+		t.Errorf("%v is not ErrToolFailure", err)
+	}
+}
+
+// TestCheckResult -
+func TestCheckResult(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "PROJECT", "1 сезон")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "600x600.jpg")
+	writeJPG(t, path, gradient(60, 60, false, 0), 0)
+
+	res, err := CheckImage(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Key == nil || res.Hash != "./1 сезон/600x600.jpg" || res.Name != "PROJECT" || res.ProjectDir != filepath.Dir(dir) ||
+		res.Type != "gp" || res.Limit != 700*kb {
+		t.Errorf("CheckImage() = %+v", res)
+	}
+}
+
+// TestSeverity -
+func TestSeverity(t *testing.T) {
+	defer func(list []TRuleTemplate, sev map[string]string, profileSev map[string]map[string]string) {
+		ruleTemplates = list
+		severities = sev
+		profileSeverities = profileSev
+		Strict = false
+		rebuildTable()
+	}(ruleTemplates, severities, profileSeverities)
+
+	dir := filepath.Join(t.TempDir(), "PROJECT")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	black := image.NewGray(image.Rect(0, 0, 60, 60))
+	for _, name := range []string{"600x600.jpg", "350x500.jpg", "100x100.jpg"} {
+		writeJPG(t, filepath.Join(dir, name), black, 0)
+	}
+
+	check := func(name, want string) {
+		t.Helper()
+		res, err := CheckImage(filepath.Join(dir, name), nil)
+		got := ""
+		switch {
+		case err != nil:
+			got = SeverityError
+		case len(res.Findings) > 0:
+			got = res.Findings[0].Severity
+		}
+		if got != want {
+			t.Errorf("%v: severity %q, want %q (%v, %v)", name, got, want, res, err)
+		}
+	}
+
+	check("600x600.jpg", SeverityWarning)
+
+	config := &TConfig{
+		Rules: []TRuleTemplate{
+			{Profile: "test", Type: "gp", Sizes: []string{"100x100"}, Exts: map[string]int64{".jpg": none},
+				Severity: map[string]string{FindingUniform: SeverityError}},
+		},
+		ProfileSeverity: map[string]map[string]string{"gp": {FindingUniform: SeverityInfo}},
+	}
+	if err := config.Apply(); err != nil {
+		t.Fatal(err)
+	}
+	check("600x600.jpg", SeverityInfo)
+	check("350x500.jpg", SeverityWarning)
+	check("100x100.jpg", SeverityError)
+
+	Strict = true
+	check("600x600.jpg", SeverityInfo)
+	check("350x500.jpg", SeverityError)
+	Strict = false
+
+	config = &TConfig{Severity: map[string]string{FindingUniform: SeverityInfo}}
+	if err := config.Apply(); err != nil {
+		t.Fatal(err)
+	}
+	check("350x500.jpg", SeverityInfo)
+
+	// dropped
+	config = &TConfig{Severity: map[string]string{FindingUniform: SeverityOff}}
+	if err := config.Apply(); err != nil {
+		t.Fatal(err)
+	}
+	check("350x500.jpg", "")
+
+	for _, config := range []*TConfig{
+		{Severity: map[string]string{"unknown": SeverityInfo}},
+		{Severity: map[string]string{FindingUniform: "fatal"}},
+		{ProfileSeverity: map[string]map[string]string{"unknown": {FindingUniform: SeverityInfo}}},
+	} {
+		if err := config.Apply(); err == nil {
+			t.Errorf("Apply(%+v) must fail", config)
+		}
+	}
+}
+
+// TestCheckImageFS -
+func TestCheckImageFS(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if err := jpeg.Encode(buf, gradient(60, 60, false, 0), nil); err != nil {
+		t.Fatal(err)
+	}
+	fsys := fstest.MapFS{
+		"PROJECT/600x600.jpg":   {Data: buf.Bytes()},
+		"PROJECT/600x840.jpg":   {Data: buf.Bytes()[:buf.Len()/2]},
+		"PROJECT/600x600.psd":   {Data: []byte("8BPS...")},
+		"PROJECT/1920x1080.psd": {Data: []byte("not a psd")},
+		"PROJECT/123x456.jpg":   {Data: buf.Bytes()},
+	}
+	type tCase struct {
+		name string
+		err  error
+	}
+	for _, v := range []tCase{
+		{"PROJECT/600x600.jpg", nil},
+		{"PROJECT/600x600.psd", nil},
+		{"PROJECT/123x456.jpg", ErrKeyNotFound},
+		{"PROJECT/1920x1080.jpg", fs.ErrNotExist},
+	} {
+		res, err := CheckImageFS(fsys, v.name, v.name, nil)
+		if !errors.Is(err, v.err) || (err == nil && res.Name != "PROJECT") {
+			t.Errorf("CheckImageFS(%q) = %v, %v, want %v", v.name, res, err, v.err)
+		}
+	}
+	// an archive entry is found by its virtual path
+	if res, err := CheckImageFS(fsys, "PROJECT/600x600.jpg", "x/OTHER/600x600.jpg", nil); err != nil || res.Name != "OTHER" {
+		t.Errorf("CheckImageFS() by a virtual path = %v, %v", res, err)
+	}
+	for _, name := range []string{"PROJECT/600x840.jpg", "PROJECT/1920x1080.psd"} {
+		if _, err := CheckImageFS(fsys, name, name, nil); err == nil {
+			t.Errorf("CheckImageFS(%q) has no error", name)
+		}
+	}
+}
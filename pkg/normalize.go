@@ -0,0 +1,76 @@
+package rtimg
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// extension -> extension of <key>s it is matched as
+var defaultExtAliases = map[string]string{
+	".jpeg": ".jpg",
+	".jpe":  ".jpg",
+}
+
+var extAliases = defaultExtAliases
+
+// canonical hashes of postersTable by normalized ones (see rebuildTable)
+var normalizedHashes = map[string]string{}
+
+// NormalizeExt lower-cases the extension and resolves its alias (".JPEG" -> ".jpg").
+func NormalizeExt(ext string) string {
+	ext = strings.ToLower(ext)
+	if alias, ok := extAliases[ext]; ok {
+		return alias
+	}
+	return ext
+}
+
+// normalizeName composes unicode (NFC, directories of macOS clients are in NFD).
+func normalizeName(name string) string {
+	return norm.NFC.String(name)
+}
+
+// normalizeHash is normalizeName plus case-insensitive file name and extension aliases.
+func normalizeHash(hash string) string {
+	dir, file := path.Split(normalizeName(hash))
+	ext := path.Ext(file)
+	return dir + strings.ToLower(strings.TrimSuffix(file, ext)) + NormalizeExt(ext)
+}
+
+// canonicalHash returns the postersTable hash that matches the hash after normalization
+// or the normalized hash itself.
+func canonicalHash(hash string) string {
+	ret := normalizeHash(hash)
+	if canonical, ok := normalizedHashes[ret]; ok {
+		return canonical
+	}
+	return ret
+}
+
+// mergeExtAliases adds aliases to the list (lower-cased).
+func mergeExtAliases(list, with map[string]string) (map[string]string, error) {
+	ret := map[string]string{}
+	for k, v := range list {
+		ret[k] = v
+	}
+	for k, v := range with {
+		k, v = strings.ToLower(k), strings.ToLower(v)
+		if !isExt(k) || !isExt(v) || k == v {
+			return nil, fmt.Errorf("ext alias: invalid %q -> %q", k, v)
+		}
+		ret[k] = v
+	}
+	for k, v := range ret {
+		if _, ok := ret[v]; ok {
+			return nil, fmt.Errorf("ext alias: %q -> %q is an alias itself", k, v)
+		}
+	}
+	return ret, nil
+}
+
+func isExt(s string) bool {
+	return len(s) > 1 && strings.HasPrefix(s, ".") && !strings.ContainsAny(s[1:], `./\`)
+}
@@ -0,0 +1,238 @@
+package rtimg
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type (
+	// TPackage - deliverables of a project for a single platform type.
+	TPackage struct {
+		Name  string
+		Type  string
+		Files []TPackFile
+	}
+	// TPackFile - a file of a package: the source and the slash separated path
+	// inside of the package.
+	TPackFile struct {
+		// the file system of the source (an archive), nil - Src is a path on disk
+		FS  fs.FS
+		Src string
+		Dst string
+	}
+)
+
+// PlanPackages groups checked files into packages by project and platform type.
+// A package is refused (returned as an error) if a file of the project failed or
+// if some of the <key>s of the platform type are missing or if another project with
+// the same name would write the same package. Sources (psd) are not packed.
+func PlanPackages(entries []TReportEntry) ([]TPackage, []error) {
+	type tProject struct {
+		name    string
+		entries []TReportEntry
+		failed  []string
+	}
+	projects := map[string]*tProject{}
+	failed := []TReportEntry{}
+	for _, v := range entries {
+		if strings.ToLower(path.Ext(v.Hash)) == ".psd" {
+			continue
+		}
+		if v.ProjectDir == "" {
+			failed = append(failed, v)
+			continue
+		}
+		p := projects[v.ProjectDir]
+		if p == nil {
+			p = &tProject{name: v.Name}
+			projects[v.ProjectDir] = p
+		}
+		if v.Status == StatusError {
+			p.failed = append(p.failed, filepath.Base(v.Path))
+			continue
+		}
+		p.entries = append(p.entries, v)
+	}
+	// files without a <key> belong to the project that contains them
+	for _, v := range failed {
+		abs, err := filepath.Abs(v.Path)
+		if err != nil {
+			abs = v.Path
+		}
+		for dir, p := range projects {
+			if strings.HasPrefix(abs, dir+string(filepath.Separator)) {
+				p.failed = append(p.failed, filepath.Base(v.Path))
+			}
+		}
+	}
+
+	dirs := []string{}
+	for dir := range projects {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+
+	type tPlanned struct {
+		dir  string
+		pack TPackage
+	}
+	planned := []tPlanned{}
+	errs := []error{}
+	for _, dir := range dirs {
+		p := projects[dir]
+		byType := map[string][]TReportEntry{}
+		found := []string{}
+		for _, v := range p.entries {
+			byType[v.Type] = append(byType[v.Type], v)
+			found = append(found, v.Hash)
+		}
+		missing := map[string][]string{}
+		for _, hash := range MissingKeys(found) {
+			typ := postersTable[hash].Type
+			missing[typ] = append(missing[typ], hash)
+		}
+		types := []string{}
+		for typ := range byType {
+			types = append(types, typ)
+		}
+		sort.Strings(types)
+		for _, typ := range types {
+			switch {
+			case len(p.failed) > 0:
+				errs = append(errs, fmt.Errorf("%v (%v): failed files: %v", dir, typ, strings.Join(p.failed, ", ")))
+				continue
+			case len(missing[typ]) > 0:
+				errs = append(errs, fmt.Errorf("%v (%v): missing keys: %v", dir, typ, strings.Join(missing[typ], ", ")))
+				continue
+			}
+			pack := TPackage{Name: p.name, Type: typ}
+			for _, v := range byType[typ] {
+				dst := path.Join(p.name, strings.TrimPrefix(v.Hash, "./"))
+				file := TPackFile{Src: v.Path, Dst: dst}
+				if v.FS != nil {
+					file.FS, file.Src = v.FS, v.FSName
+				}
+				pack.Files = append(pack.Files, file)
+			}
+			sort.Slice(pack.Files, func(i, j int) bool {
+				return pack.Files[i].Dst < pack.Files[j].Dst
+			})
+			planned = append(planned, tPlanned{dir, pack})
+		}
+	}
+
+	// projects with the same name in different directories would write the same
+	// package (the name is compared case-insensitively for such file systems)
+	byFileName := map[string][]string{}
+	for _, v := range planned {
+		name := strings.ToLower(v.pack.FileName())
+		byFileName[name] = append(byFileName[name], v.dir)
+	}
+	ret := []TPackage{}
+	for _, v := range planned {
+		if clash := byFileName[strings.ToLower(v.pack.FileName())]; len(clash) > 1 {
+			errs = append(errs, fmt.Errorf("%v (%v): package %v is planned by several projects: %v",
+				v.dir, v.pack.Type, v.pack.FileName(), strings.Join(clash, ", ")))
+			continue
+		}
+		ret = append(ret, v.pack)
+	}
+	return ret, errs
+}
+
+// FileName returns a base name for the package archive or directory.
+func (o TPackage) FileName() string {
+	return o.Name + "_" + o.Type
+}
+
+// WriteZip writes the package as <dir>/<name>_<type>.zip and returns its path.
+func (o TPackage) WriteZip(dir string) (string, error) {
+	outPath := filepath.Join(dir, o.FileName()+".zip")
+	out, err := os.Create(outPath)
+	if err != nil {
+		return "", err
+	}
+	w := zip.NewWriter(out)
+	err = func() error {
+		for _, v := range o.Files {
+			info, err := v.stat()
+			if err != nil {
+				return err
+			}
+			header, err := zip.FileInfoHeader(info)
+			if err != nil {
+				return err
+			}
+			header.Name = v.Dst
+			header.Method = zip.Deflate
+			fw, err := w.CreateHeader(header)
+			if err != nil {
+				return err
+			}
+			if err := v.copyTo(fw); err != nil {
+				return err
+			}
+		}
+		return w.Close()
+	}()
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(outPath)
+		return "", err
+	}
+	return outPath, nil
+}
+
+// WriteDir copies the package files into <dir>/<name>_<type>/ and returns its path.
+func (o TPackage) WriteDir(dir string) (string, error) {
+	outPath := filepath.Join(dir, o.FileName())
+	for _, v := range o.Files {
+		dst := filepath.Join(outPath, filepath.FromSlash(v.Dst))
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return "", err
+		}
+		out, err := os.Create(dst)
+		if err != nil {
+			return "", err
+		}
+		err = v.copyTo(out)
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return outPath, nil
+}
+
+func (o TPackFile) source() (fs.FS, string) {
+	if o.FS == nil {
+		return DirFS(o.Src)
+	}
+	return o.FS, o.Src
+}
+
+func (o TPackFile) stat() (fs.FileInfo, error) {
+	return fs.Stat(o.source())
+}
+
+func (o TPackFile) copyTo(w io.Writer) error {
+	fsys, name := o.source()
+	f, err := fsys.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
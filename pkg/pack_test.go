@@ -0,0 +1,82 @@
+package rtimg
+
+import (
+	"archive/zip"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+// TestPlanPackages -
+func TestPlanPackages(t *testing.T) {
+	rt := []string{"./350x500.jpg", "./525x300.jpg", "./810x498.jpg", "./270x390.jpg", "./1620x996.jpg", "./1006x1452.jpg"}
+	entries := []TReportEntry{}
+	for _, hash := range rt {
+		entries = append(entries,
+			TReportEntry{Path: "/a/A" + hash[1:], Name: "A", ProjectDir: "/a/A", Hash: hash, Type: "rt", Status: StatusOk},
+			TReportEntry{Path: "/a/B" + hash[1:], Name: "B", ProjectDir: "/a/B", Hash: hash, Type: "rt", Status: StatusReduced},
+		)
+	}
+	entries = append(entries,
+		// sources are not packed
+		TReportEntry{Path: "/a/A/350x500.psd", Name: "A", ProjectDir: "/a/A", Hash: "./350x500.psd", Type: "rt", Status: StatusOk},
+		// incomplete gp set
+		TReportEntry{Path: "/a/A/600x600.jpg", Name: "A", ProjectDir: "/a/A", Hash: "./600x600.jpg", Type: "gp", Status: StatusOk},
+		// a file without a <key> fails the whole project
+		TReportEntry{Path: "/a/B/bad.jpg", Status: StatusError},
+	)
+
+	packages, errs := PlanPackages(entries)
+	if len(packages) != 1 || packages[0].FileName() != "A_rt" {
+		t.Fatalf("PlanPackages() = %v", packages)
+	}
+	if len(packages[0].Files) != len(rt) {
+		t.Errorf("PlanPackages() files: %v", packages[0].Files)
+	}
+	if dst := packages[0].Files[0].Dst; dst != "A/1006x1452.jpg" {
+		t.Errorf("PlanPackages() dst: %q", dst)
+	}
+	// A: gp is incomplete, B: rt has a failed file
+	if len(errs) != 2 {
+		t.Errorf("PlanPackages() errors: %v", errs)
+	}
+
+	// another project named "a" would write the same a_rt package
+	for _, hash := range rt {
+		entries = append(entries, TReportEntry{Path: "/b/a" + hash[1:], Name: "a", ProjectDir: "/b/a", Hash: hash, Type: "rt", Status: StatusOk})
+	}
+	packages, errs = PlanPackages(entries)
+	if len(packages) != 0 || len(errs) != 4 {
+		t.Errorf("PlanPackages() = %v, %v, want no packages and 4 errors", packages, errs)
+	}
+}
+
+// TestWriteZip -
+func TestWriteZip(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "600x600.jpg")
+	if err := os.WriteFile(src, []byte("disk"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	archive := fstest.MapFS{"PROJECT/logo.png": {Data: []byte("archive")}}
+	pack := TPackage{Name: "A", Type: "gp", Files: []TPackFile{
+		{Src: src, Dst: "A/600x600.jpg"},
+		{FS: archive, Src: "PROJECT/logo.png", Dst: "A/logo.png"},
+	}}
+	outPath, err := pack.WriteZip(dir)
+	if err != nil {
+		t.Fatalf("WriteZip() error: %v", err)
+	}
+	r, err := zip.OpenReader(outPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	for name, want := range map[string]string{"A/600x600.jpg": "disk", "A/logo.png": "archive"} {
+		if data, err := fs.ReadFile(r, name); err != nil || string(data) != want {
+			t.Errorf("WriteZip() %v: %q, %v, want %q", name, data, err, want)
+		}
+	}
+}
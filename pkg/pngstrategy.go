@@ -0,0 +1,123 @@
+package rtimg
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// kinds of PNG strategies
+const (
+	PNGLossless = "lossless"
+	PNGQuant    = "pngquant"
+)
+
+// TPNGStrategy - one attempt to reduce a PNG. Strategies are tried in order and the
+// first result under the limit wins, so they go from the best quality to the worst.
+type TPNGStrategy struct {
+	// PNGLossless - recompression with the best compression, PNGQuant - quantization
+	Kind string `json:"kind"`
+	// pngquant --quality "min-max" ("" - "0-100"), pngquant gives up if it cannot reach min
+	Quality string `json:"quality"`
+	// pngquant number of colors (0 - 256)
+	Colors int `json:"colors"`
+	// pngquant without Floyd-Steinberg dithering
+	NoDither bool `json:"no_dither"`
+	// downscale factor before quantization (0 - none), only for <key>s that allow it
+	Scale float64 `json:"scale"`
+}
+
+// TPNGConstraints - what PNG strategies may do with a <key>.
+type TPNGConstraints struct {
+	// strategies with Scale are skipped unless it is set. A downscaled file no longer
+	// has the size of its <key> name (600x600.png becomes 300x300 with the scale 0.5),
+	// so it is only for <key>s whose platforms accept any size.
+	AllowDownscale bool `json:"allow_downscale"`
+}
+
+func (o TPNGStrategy) String() string {
+	if o.Kind == PNGLossless {
+		return "best-compression"
+	}
+	ret := []string{o.Kind}
+	if o.Quality != "" {
+		ret = append(ret, "quality:"+o.Quality)
+	}
+	if o.Colors > 0 {
+		ret = append(ret, fmt.Sprintf("colors:%v", o.Colors))
+	}
+	if o.NoDither {
+		ret = append(ret, "no-dither")
+	}
+	if o.Scale > 0 {
+		ret = append(ret, fmt.Sprintf("scale:%v", o.Scale))
+	}
+	return strings.Join(ret, " ")
+}
+
+var defaultPNGStrategies = []TPNGStrategy{
+	{Kind: PNGLossless},
+	{Kind: PNGQuant, Quality: "90-100"},
+	{Kind: PNGQuant, Quality: "75-95"},
+	{Kind: PNGQuant, Quality: "60-85"},
+	{Kind: PNGQuant, Quality: "40-70"},
+	{Kind: PNGQuant, Quality: "0-100"},
+	{Kind: PNGQuant, Quality: "0-100", Colors: 128},
+	{Kind: PNGQuant, Quality: "0-100", Colors: 64, NoDither: true},
+	{Kind: PNGQuant, Quality: "0-100", Scale: 0.75},
+	{Kind: PNGQuant, Quality: "0-100", Scale: 0.5},
+}
+
+var pngStrategies = defaultPNGStrategies
+
+var reQualityRange = regexp.MustCompile(`^(\d+)-(\d+)$`)
+
+// checkPNGStrategies validates the strategy chain.
+func checkPNGStrategies(list []TPNGStrategy) error {
+	if len(list) == 0 {
+		return fmt.Errorf("png strategies: the list is empty")
+	}
+	for i, v := range list {
+		switch v.Kind {
+		default:
+			return fmt.Errorf("png strategy %v: unknown kind %q", i, v.Kind)
+		case PNGLossless:
+			if v != (TPNGStrategy{Kind: PNGLossless}) {
+				return fmt.Errorf("png strategy %v: %v takes no parameters", i, v.Kind)
+			}
+			continue
+		case PNGQuant:
+		}
+		if v.Quality != "" {
+			m := reQualityRange.FindStringSubmatch(v.Quality)
+			if m == nil {
+				return fmt.Errorf("png strategy %v: invalid quality %q", i, v.Quality)
+			}
+			min, _ := strconv.Atoi(m[1])
+			max, _ := strconv.Atoi(m[2])
+			if min > max || max > 100 {
+				return fmt.Errorf("png strategy %v: invalid quality %q", i, v.Quality)
+			}
+		}
+		if v.Colors != 0 && (v.Colors < 2 || v.Colors > 256) {
+			return fmt.Errorf("png strategy %v: colors %v out of 2..256", i, v.Colors)
+		}
+		if v.Scale < 0 || v.Scale >= 1 {
+			return fmt.Errorf("png strategy %v: scale %v out of (0, 1)", i, v.Scale)
+		}
+	}
+	return nil
+}
+
+// pngStrategiesFor returns strategies allowed by the constraints.
+func pngStrategiesFor(c TPNGConstraints) []TPNGStrategy {
+	ret := []TPNGStrategy{}
+	for _, v := range pngStrategies {
+		if v.Scale > 0 && !c.AllowDownscale {
+			continue
+		}
+		ret = append(ret, v)
+	}
+	return ret
+}
@@ -0,0 +1,75 @@
+package rtimg
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// tProfileError - a path matches <key>s only of inactive profiles.
+type tProfileError struct {
+	profiles []string
+}
+
+func (o *tProfileError) Error() string {
+	return fmt.Sprintf("tryToFindKey(): <key> matches only inactive profile(s) %v", strings.Join(o.profiles, ", "))
+}
+
+func (o *tProfileError) Is(target error) bool {
+	return target == ErrKeyNotFound
+}
+
+// active profiles, nil - all of them
+var activeProfiles map[string]bool
+
+// Profiles returns sorted names of all profiles of the table.
+func Profiles() []string {
+	set := map[string]bool{}
+	for _, v := range postersTable {
+		set[v.Profile] = true
+	}
+	ret := []string{}
+	for name := range set {
+		ret = append(ret, name)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// SetProfiles restricts <key>s to the profiles. An empty list turns all of them on.
+func SetProfiles(names []string) error {
+	if len(names) == 0 {
+		activeProfiles = nil
+		return nil
+	}
+	known := map[string]bool{}
+	for _, name := range Profiles() {
+		known[name] = true
+	}
+	set := map[string]bool{}
+	for _, name := range names {
+		if !known[name] {
+			return fmt.Errorf("unknown profile %q (known: %v)", name, Profiles())
+		}
+		set[name] = true
+	}
+	activeProfiles = set
+	return nil
+}
+
+func isProfileActive(name string) bool {
+	return activeProfiles == nil || activeProfiles[name]
+}
+
+// lookupKey returns <key> data of the hash if its profile is active. If it is not,
+// the profile is returned as the second value.
+func lookupKey(hash string) (*TKeyData, string) {
+	data, ok := postersTable[hash]
+	if !ok {
+		return nil, ""
+	}
+	if !isProfileActive(data.Profile) {
+		return nil, data.Profile
+	}
+	return data, ""
+}
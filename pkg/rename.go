@@ -0,0 +1,174 @@
+package rtimg
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// rename layouts
+const (
+	// <project dir>/<key hash>, for example "PROJECT/для сервиса/600x600.jpg"
+	LayoutDir = "dir"
+	// <dir>/<tagname>, the tagname of the file with the size tag of the <key>, for
+	// example "dir/sd_2018_sobibor__12_q0w2_ar2_poster525x300.jpg"
+	LayoutTagname = "tag"
+)
+
+// TagnameParser makes a tagname of a file name (tagname.NewFromFilename). LayoutTagname
+// needs it to check that the target name gives the same <key>.
+var TagnameParser func(filePath string) (ITagname, error)
+
+// TRename - a planned rename of a file.
+type TRename struct {
+	From string
+	To   string
+}
+
+// CanonicalPath returns the path the file should have in the layout.
+func CanonicalPath(filePath string, tn ITagname, layout string) (string, error) {
+	key, err := FindKey(filePath, tn)
+	if err != nil {
+		return "", err
+	}
+	name := key.Name()
+	if name == "" {
+		return "", fmt.Errorf("canonical path: cannot find a project name")
+	}
+	hash := strings.TrimPrefix(key.Hash(), "./")
+	projectDir := key.ProjectDir()
+	if key.name != "" {
+		// the <key> was made using tags, so there is no project directory yet
+		projectDir = filepath.Join(projectDir, name)
+	}
+
+	switch layout {
+	default:
+		return "", fmt.Errorf("canonical path: unknown layout %q", layout)
+	case LayoutDir:
+		return filepath.Join(projectDir, filepath.FromSlash(hash)), nil
+	case LayoutTagname:
+		if strings.Contains(hash, "/") {
+			return "", fmt.Errorf("canonical path: %v cannot be expressed as a tagname", key.Hash())
+		}
+		base, err := tagnameBase(key, tn)
+		if err != nil {
+			return "", err
+		}
+		ret := filepath.Join(filepath.Dir(filePath), base)
+		if err := checkTagnameKey(ret, key); err != nil {
+			return "", err
+		}
+		return ret, nil
+	}
+}
+
+// tagnameBase replaces the size tag of the tagname the <key> was made of with the
+// base of the <key> hash.
+func tagnameBase(key *TKey, tn ITagname) (string, error) {
+	if key.name == "" || tn == nil {
+		return "", fmt.Errorf("canonical path: %v has no tags to make a tagname of", key.Base())
+	}
+	size, err := tn.GetTag("sizetag")
+	if err != nil {
+		return "", fmt.Errorf("canonical path: %v", err)
+	}
+	if align, _ := tn.GetTag("aligntag"); align != "" {
+		size += "_" + align
+	}
+	src := filepath.Base(tn.Source())
+	stem := strings.TrimSuffix(src, filepath.Ext(src))
+	if !strings.HasSuffix(stem, size) {
+		return "", fmt.Errorf("canonical path: cannot find size tag %q in %v", size, src)
+	}
+	return strings.TrimSuffix(stem, size) + path.Base(key.Hash()), nil
+}
+
+// checkTagnameKey parses the target name and checks that it gives the same <key>.
+func checkTagnameKey(target string, key *TKey) error {
+	if TagnameParser == nil {
+		return fmt.Errorf("canonical path: there is no tagname parser")
+	}
+	tn, err := TagnameParser(target)
+	if err != nil {
+		return fmt.Errorf("canonical path: %v is not a valid tagname: %v", filepath.Base(target), err)
+	}
+	got, err := FindKey(target, tn)
+	if err != nil {
+		return fmt.Errorf("canonical path: %v: %v", filepath.Base(target), err)
+	}
+	if got.Hash() != key.Hash() || got.Name() != key.Name() {
+		return fmt.Errorf("canonical path: %v gives %v of %q instead of %v of %q",
+			filepath.Base(target), got.Hash(), got.Name(), key.Hash(), key.Name())
+	}
+	return nil
+}
+
+// PlanRenames drops no-op renames and returns errors for conflicting ones: several
+// files with the same target or a target that already exists. A target that is the
+// source itself (a case-only rename on a case-insensitive file system) is allowed.
+func PlanRenames(list []TRename) ([]TRename, []error) {
+	return planRenames(list, filepath.Clean, func(from, to string) bool {
+		target, err := os.Lstat(to)
+		if err != nil {
+			return false
+		}
+		source, err := os.Lstat(from)
+		return err != nil || !os.SameFile(source, target)
+	})
+}
+
+// PlanRenamesFS is PlanRenames for the (slash separated) names of the file system
+// (entries of an archive).
+func PlanRenamesFS(fsys fs.FS, list []TRename) ([]TRename, []error) {
+	return planRenames(list, path.Clean, func(_, to string) bool {
+		_, err := fs.Stat(fsys, to)
+		return err == nil
+	})
+}
+
+func planRenames(list []TRename, clean func(string) string, exists func(from, to string) bool) ([]TRename, []error) {
+	byTarget := map[string][]string{}
+	for _, v := range list {
+		if clean(v.From) == clean(v.To) {
+			continue
+		}
+		to := clean(v.To)
+		byTarget[to] = append(byTarget[to], v.From)
+	}
+
+	targets := []string{}
+	for to := range byTarget {
+		targets = append(targets, to)
+	}
+	sort.Strings(targets)
+
+	ret := []TRename{}
+	errs := []error{}
+	for _, to := range targets {
+		from := byTarget[to]
+		if len(from) > 1 {
+			sort.Strings(from)
+			errs = append(errs, fmt.Errorf("%v: several files: %v", to, strings.Join(from, ", ")))
+			continue
+		}
+		if exists(from[0], to) {
+			errs = append(errs, fmt.Errorf("%v: already exists (%v)", to, from[0]))
+			continue
+		}
+		ret = append(ret, TRename{From: from[0], To: to})
+	}
+	return ret, errs
+}
+
+// ApplyRename renames the file creating missing directories of the target.
+func ApplyRename(v TRename) error {
+	if err := os.MkdirAll(filepath.Dir(v.To), 0755); err != nil {
+		return err
+	}
+	return os.Rename(v.From, v.To)
+}
@@ -0,0 +1,162 @@
+package rtimg
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// parseFakeTagname understands "<name>__<tags>_poster<size>.<ext>"
+func parseFakeTagname(filePath string) (ITagname, error) {
+	base := filepath.Base(filePath)
+	stem := strings.TrimSuffix(base, filepath.Ext(base))
+	i := strings.Index(stem, "__")
+	j := strings.LastIndex(stem, "_poster")
+	if i < 1 || j < i {
+		return nil, fmt.Errorf("%v is not a tagname", base)
+	}
+	return fakeTagname{"source": filePath, "name": stem[:i], "sizetag": stem[j+len("_poster"):]}, nil
+}
+
+// TestCanonicalPath -
+func TestCanonicalPath(t *testing.T) {
+	defer func(parser func(string) (ITagname, error)) {
+		TagnameParser = parser
+	}(TagnameParser)
+	TagnameParser = parseFakeTagname
+
+	tagged := func(p string) ITagname {
+		tn, err := parseFakeTagname(p)
+		if err != nil {
+			t.Fatalf("parseFakeTagname(%q) error: %v", p, err)
+		}
+		return tn
+	}
+	table := []struct {
+		path   string
+		tn     ITagname
+		layout string
+		want   string
+	}{
+		{"x/PROJECT/600X600.JPEG", nil, LayoutDir, "x/PROJECT/600x600.jpg"},
+		{"x/PROJECT/для сервиса/600x600.jpg", nil, LayoutDir, "x/PROJECT/для сервиса/600x600.jpg"},
+		{"x/sobibor__q0_poster600x600.jpg", tagged("x/sobibor__q0_poster600x600.jpg"), LayoutDir, "x/sobibor/600x600.jpg"},
+		{"x/sobibor__q0_poster600x600.JPEG", tagged("x/sobibor__q0_poster600x600.JPEG"), LayoutTagname, "x/sobibor__q0_poster600x600.jpg"},
+		{"x/sobibor__q0_posterlogo.png", tagged("x/sobibor__q0_posterlogo.png"), LayoutTagname, "x/sobibor__q0_posterlogo.png"},
+		// errors
+		{"x/PROJECT/600x600.jpg", nil, LayoutTagname, ""},
+		{"x/PROJECT/для сервиса/600x600.jpg", nil, LayoutTagname, ""},
+		{"x/PROJECT/600x600.jpg", nil, "unknown", ""},
+		{"600x600.jpg", nil, LayoutDir, ""},
+	}
+	for _, v := range table {
+		got, err := CanonicalPath(filepath.FromSlash(v.path), v.tn, v.layout)
+		if v.want == "" {
+			if err == nil {
+				t.Errorf("CanonicalPath(%q, %q) = %q, want an error", v.path, v.layout, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("CanonicalPath(%q, %q) error: %v", v.path, v.layout, err)
+			continue
+		}
+		if got != filepath.FromSlash(v.want) {
+			t.Errorf("CanonicalPath(%q, %q) = %q, want %q", v.path, v.layout, got, v.want)
+		}
+	}
+
+	// the target must give the same <key>
+	src := "x/sobibor__q0_poster600x600.jpg"
+	TagnameParser = func(filePath string) (ITagname, error) {
+		return fakeTagname{"source": filePath, "name": "other", "sizetag": "600x600"}, nil
+	}
+	if got, err := CanonicalPath(src, tagged(src), LayoutTagname); err == nil {
+		t.Errorf("CanonicalPath(%q) = %q with another name, want an error", src, got)
+	}
+	TagnameParser = nil
+	if got, err := CanonicalPath(src, tagged(src), LayoutTagname); err == nil {
+		t.Errorf("CanonicalPath(%q) = %q without a parser, want an error", src, got)
+	}
+}
+
+// TestPlanRenames -
+func TestPlanRenames(t *testing.T) {
+	dir := t.TempDir()
+	p := func(name string) string {
+		return filepath.Join(dir, name)
+	}
+	if err := os.WriteFile(p("exists.jpg"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	// the same file by another name as on a case-insensitive file system
+	if err := os.WriteFile(p("g.jpg"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Link(p("g.jpg"), p("G.jpg")); err != nil {
+		t.Skipf("cannot link: %v", err)
+	}
+	list := []TRename{
+		{From: p("a.jpg"), To: p("a.jpg")},
+		{From: p("b.jpg"), To: p("x/../b.jpg")},
+		{From: p("c.jpg"), To: p("c2.jpg")},
+		{From: p("d.jpg"), To: p("dup.jpg")},
+		{From: p("e.jpg"), To: p("dup.jpg")},
+		{From: p("f.jpg"), To: p("exists.jpg")},
+		{From: p("g.jpg"), To: p("G.jpg")},
+	}
+	got, errs := PlanRenames(list)
+	want := []TRename{{From: p("g.jpg"), To: p("G.jpg")}, {From: p("c.jpg"), To: p("c2.jpg")}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("PlanRenames() = %v, want %v", got, want)
+	}
+	if len(errs) != 2 {
+		t.Fatalf("PlanRenames() returns %v errors, want 2: %v", len(errs), errs)
+	}
+	if s := errs[0].Error(); !strings.Contains(s, "d.jpg") || !strings.Contains(s, "e.jpg") {
+		t.Errorf("PlanRenames() error %q, want several files", s)
+	}
+	if s := errs[1].Error(); !strings.Contains(s, "already exists") {
+		t.Errorf("PlanRenames() error %q, want an existing target", s)
+	}
+}
+
+// TestPlanRenamesFS -
+func TestPlanRenamesFS(t *testing.T) {
+	fsys := fstest.MapFS{"PROJECT/600X600.JPEG": {}, "PROJECT/logo.PNG": {}, "PROJECT/logo.png": {}}
+	list := []TRename{
+		{From: "PROJECT/600X600.JPEG", To: "PROJECT/600x600.jpg"},
+		{From: "PROJECT/logo.PNG", To: "PROJECT/logo.png"},
+		{From: "PROJECT/600x840.jpg", To: "PROJECT/./600x840.jpg"},
+	}
+	got, errs := PlanRenamesFS(fsys, list)
+	if len(got) != 1 || got[0] != list[0] || len(errs) != 1 {
+		t.Errorf("PlanRenamesFS() = %v, %v", got, errs)
+	}
+}
+
+// TestApplyRename -
+func TestApplyRename(t *testing.T) {
+	dir := t.TempDir()
+	from := filepath.Join(dir, "a.jpg")
+	to := filepath.Join(dir, "PROJECT", "для сервиса", "600x600.jpg")
+	if err := os.WriteFile(from, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ApplyRename(TRename{From: from, To: to}); err != nil {
+		t.Fatalf("ApplyRename() error: %v", err)
+	}
+	if data, err := os.ReadFile(to); err != nil || string(data) != "data" {
+		t.Errorf("ApplyRename() target: %q, %v", data, err)
+	}
+	if _, err := os.Stat(from); !os.IsNotExist(err) {
+		t.Errorf("ApplyRename() source still exists: %v", err)
+	}
+	if err := ApplyRename(TRename{From: from, To: to + "2"}); err == nil {
+		t.Errorf("ApplyRename() of a missing file, want an error")
+	}
+}
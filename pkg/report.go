@@ -0,0 +1,262 @@
+package rtimg
+
+import (
+	"bytes"
+	"encoding/base64"
+	"html/template"
+	"image"
+	"image/jpeg"
+	"io"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// report statuses
+const (
+	StatusOk      = "ok"
+	StatusWarning = "warning"
+	StatusReduced = "reduced"
+	StatusError   = "error"
+)
+
+type (
+	// TReportEntry - the result of processing of a single file.
+	TReportEntry struct {
+		Path       string
+		Name       string
+		ProjectDir string
+		Hash       string
+		Type       string
+		Season     int
+		Episode    int
+		Status     string
+		Message    string
+		Warnings   []string
+		Infos      []string
+		// nearest <key>s if there is no <key> (see Suggest)
+		Suggestions []string
+		Size        int64
+		Limit       int64
+		Q           int
+		// the stage and encoding parameters of a reduced file (see TReduceResult)
+		Stage  string
+		Params string
+		Thumb  template.URL
+		// the file system of an archive entry and the name of the entry in it (Path
+		// is a virtual path then), nil - Path is a file on disk
+		FS     fs.FS
+		FSName string
+	}
+	tReportProject struct {
+		Dir     string
+		Entries []TReportEntry
+		Missing []string
+	}
+	tReport struct {
+		Created  string
+		Total    int
+		Counts   map[string]int
+		Projects []tReportProject
+	}
+)
+
+// SetKey fills the <key> fields of the entry.
+func (o *TReportEntry) SetKey(key *TKey) {
+	o.Name = key.Name()
+	o.ProjectDir = key.ProjectDir()
+	o.Hash = key.Hash()
+	o.Season = key.Season()
+	o.Episode = key.Episode()
+	if data := key.Data(); data != nil {
+		o.Type = data.Type
+	}
+}
+
+// MissingKeys returns <key> hashes of the same profile, directory and extension
+// as some of the found ones that are not in the found list.
+func MissingKeys(found []string) []string {
+	type group struct{ profile, dir, ext string }
+	groups := map[group]bool{}
+	isFound := map[string]bool{}
+	for _, hash := range found {
+		isFound[hash] = true
+		data, _ := lookupKey(hash)
+		if data == nil {
+			continue
+		}
+		groups[group{data.Profile, path.Dir(hash), path.Ext(hash)}] = true
+	}
+	ret := []string{}
+	for hash, data := range postersTable {
+		if isFound[hash] || !groups[group{data.Profile, path.Dir(hash), path.Ext(hash)}] {
+			continue
+		}
+		ret = append(ret, hash)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// Thumbnail returns the image name of the file system downscaled to fit maxSize as
+// a data URL.
+func Thumbnail(fsys fs.FS, name string, maxSize int) (template.URL, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return "", err
+	}
+	img, _, err := image.Decode(f)
+	f.Close()
+	if err != nil {
+		return "", err
+	}
+	buf := &bytes.Buffer{}
+	err = jpeg.Encode(buf, downscale(img, maxSize), &jpeg.Options{Quality: 80})
+	if err != nil {
+		return "", err
+	}
+	return template.URL("data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(buf.Bytes())), nil
+}
+
+// downscale averages source pixels into a picture that fits a maxSize x maxSize box.
+func downscale(img image.Image, maxSize int) image.Image {
+	rect := img.Bounds()
+	w, h := rect.Dx(), rect.Dy()
+	if w <= maxSize && h <= maxSize {
+		return img
+	}
+	tw, th := maxSize, h*maxSize/w
+	if h > w {
+		tw, th = w*maxSize/h, maxSize
+	}
+	if tw < 1 {
+		tw = 1
+	}
+	if th < 1 {
+		th = 1
+	}
+	sum := make([][4]uint64, tw*th)
+	count := make([]uint64, tw*th)
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		ty := (y - rect.Min.Y) * th / h
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			i := ty*tw + (x-rect.Min.X)*tw/w
+			r, g, b, a := img.At(x, y).RGBA()
+			sum[i][0] += uint64(r)
+			sum[i][1] += uint64(g)
+			sum[i][2] += uint64(b)
+			sum[i][3] += uint64(a)
+			count[i]++
+		}
+	}
+	ret := image.NewRGBA(image.Rect(0, 0, tw, th))
+	for i := range sum {
+		n := count[i]
+		if n == 0 {
+			continue
+		}
+		ret.Pix[i*4+0] = uint8(sum[i][0] / n >> 8)
+		ret.Pix[i*4+1] = uint8(sum[i][1] / n >> 8)
+		ret.Pix[i*4+2] = uint8(sum[i][2] / n >> 8)
+		ret.Pix[i*4+3] = uint8(sum[i][3] / n >> 8)
+	}
+	return ret
+}
+
+// WriteHTMLReport writes a self-contained html report grouped by project directories.
+func WriteHTMLReport(w io.Writer, entries []TReportEntry) error {
+	report := tReport{
+		Created: time.Now().Format("2006-01-02 15:04:05"),
+		Total:   len(entries),
+		Counts:  map[string]int{},
+	}
+	projects := map[string][]TReportEntry{}
+	for _, v := range entries {
+		report.Counts[v.Status]++
+		dir := v.ProjectDir
+		if dir == "" {
+			dir = filepath.Dir(v.Path)
+		}
+		projects[dir] = append(projects[dir], v)
+	}
+	for dir, list := range projects {
+		sort.Slice(list, func(i, j int) bool {
+			return list[i].Path < list[j].Path
+		})
+		found := []string{}
+		for _, v := range list {
+			if v.Hash != "" {
+				found = append(found, v.Hash)
+			}
+		}
+		report.Projects = append(report.Projects, tReportProject{Dir: dir, Entries: list, Missing: MissingKeys(found)})
+	}
+	sort.Slice(report.Projects, func(i, j int) bool {
+		return report.Projects[i].Dir < report.Projects[j].Dir
+	})
+	return reportTemplate.Execute(w, report)
+}
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"base": filepath.Base,
+	"kb": func(n int64) int64 {
+		return n / kb
+	},
+	"percent": func(size, limit int64) int64 {
+		if limit <= 0 {
+			return 0
+		}
+		return size * 100 / limit
+	},
+	"join": strings.Join,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>rtimg report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; background: #f4f4f4; color: #222; }
+h2 { border-bottom: 1px solid #ccc; padding-bottom: .2em; word-break: break-all; }
+.cards { display: flex; flex-wrap: wrap; gap: 1em; }
+.card { background: #fff; border-radius: 4px; padding: .6em; width: 220px; box-shadow: 0 1px 3px #0003; font-size: 13px; }
+.card img { display: block; max-width: 200px; max-height: 200px; margin: 0 auto .4em; }
+.name { font-weight: bold; word-break: break-all; }
+.badge { display: inline-block; padding: 0 .5em; border-radius: 3px; color: #fff; font-size: 12px; }
+.ok { background: #2a2; } .warning { background: #c90; } .reduced { background: #c60; } .error { background: #c22; }
+.bar { height: 6px; background: #ddd; border-radius: 3px; margin: .3em 0; }
+.bar div { height: 100%; max-width: 100%; border-radius: 3px; background: #2a2; }
+.bar .over { background: #c22; }
+.msg { color: #a00; word-break: break-word; } .warn { color: #960; } .info { color: #777; }
+.missing { color: #a00; } .hint { color: #258; }
+</style>
+</head>
+<body>
+<h1>rtimg report</h1>
+<p>{{.Created}}: {{.Total}} file(s){{range $k, $v := .Counts}}, <span class="badge {{$k}}">{{$k}}</span> {{$v}}{{end}}</p>
+{{range .Projects}}
+<h2>{{.Dir}}</h2>
+{{if .Missing}}<p class="missing">missing: {{join .Missing ", "}}</p>{{end}}
+<div class="cards">
+{{range .Entries}}
+<div class="card">
+{{if .Thumb}}<img src="{{.Thumb}}" alt="{{base .Path}}">{{end}}
+<div class="name">{{base .Path}}</div>
+<span class="badge {{.Status}}">{{.Status}}</span>{{if .Type}} {{.Type}}{{end}}{{if ge .Season 0}} s{{.Season}}{{end}}{{if ge .Episode 0}} e{{.Episode}}{{end}}{{if .Stage}} {{.Stage}}: {{.Params}}{{else if ge .Q 0}} q: {{.Q}}{{end}}
+{{if gt .Limit 0}}
+<div class="bar"><div{{if gt .Size .Limit}} class="over"{{end}} style="width: {{percent .Size .Limit}}%"></div></div>
+<div>{{kb .Size}} KB / {{kb .Limit}} KB</div>
+{{end}}
+{{if .Message}}<div class="msg">{{.Message}}</div>{{end}}
+{{range .Warnings}}<div class="warn">{{.}}</div>{{end}}
+{{range .Infos}}<div class="info">{{.}}</div>{{end}}
+{{range .Suggestions}}<div class="hint">{{.}}</div>{{end}}
+</div>
+{{end}}
+</div>
+{{end}}
+</body>
+</html>
+`))
@@ -0,0 +1,125 @@
+package rtimg
+
+import (
+	"bytes"
+	"image"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestMissingKeys -
+func TestMissingKeys(t *testing.T) {
+	table := []struct {
+		found []string
+		want  []string
+	}{
+		{nil, []string{}},
+		{[]string{"./unknown.jpg"}, []string{}},
+		{
+			[]string{"./525x300.jpg", "./810x498.jpg", "./unknown.jpg"},
+			[]string{"./1006x1452.jpg", "./1620x996.jpg", "./270x390.jpg", "./350x500.jpg"},
+		},
+		{
+			[]string{"./logo.png", "./для сервиса/1920x1080.jpg"},
+			[]string{"./для сервиса/1080x540.jpg", "./для сервиса/1760x557.jpg", "./для сервиса/600x600.jpg", "./для сервиса/600x840.jpg"},
+		},
+		{
+			[]string{"./google_apple_feed/psd/g_hasLogo_600x600.psd"},
+			[]string{
+				"./google_apple_feed/psd/g_hasTitle_logo_1800x1000.psd",
+				"./google_apple_feed/psd/g_iconic_background_1000x1500.psd",
+				"./google_apple_feed/psd/g_iconic_background_3840x2160.psd",
+				"./google_apple_feed/psd/g_iconic_poster_1000x1500.psd",
+				"./google_apple_feed/psd/g_iconic_poster_3840x2160.psd",
+				"./google_apple_feed/psd/g_iconic_poster_600x600.psd",
+				"./google_apple_feed/psd/g_iconic_poster_600x800.psd",
+				"./google_apple_feed/psd/g_iconic_poster_800x600.psd",
+			},
+		},
+	}
+	for _, v := range table {
+		if got := MissingKeys(v.found); !reflect.DeepEqual(got, v.want) {
+			t.Errorf("MissingKeys(%q) = %q, want %q", v.found, got, v.want)
+		}
+	}
+}
+
+// TestDownscale -
+func TestDownscale(t *testing.T) {
+	table := []struct {
+		w, h    int
+		maxSize int
+		tw, th  int
+	}{
+		{100, 50, 200, 100, 50},
+		{200, 200, 200, 200, 200},
+		{1920, 1080, 200, 200, 112},
+		{350, 500, 200, 140, 200},
+		{1000, 1, 200, 200, 1},
+		{1, 1000, 200, 1, 200},
+	}
+	for _, v := range table {
+		src := gradient(v.w, v.h, false, 0)
+		img := downscale(src, v.maxSize)
+		r := img.Bounds()
+		if r.Dx() != v.tw || r.Dy() != v.th {
+			t.Errorf("downscale(%vx%v, %v) = %vx%v, want %vx%v", v.w, v.h, v.maxSize, r.Dx(), r.Dy(), v.tw, v.th)
+		}
+		if r.Dx() > v.maxSize || r.Dy() > v.maxSize {
+			t.Errorf("downscale(%vx%v, %v) = %vx%v does not fit", v.w, v.h, v.maxSize, r.Dx(), r.Dy())
+		}
+	}
+
+	// averaged pixels keep the gradient
+	img := downscale(gradient(400, 100, false, 0), 100).(*image.RGBA)
+	if l, r := img.RGBAAt(0, 0).R, img.RGBAAt(99, 0).R; l > 8 || r < 247 {
+		t.Errorf("downscale() gradient from %v to %v, want 0..255", l, r)
+	}
+}
+
+// TestWriteHTMLReport -
+func TestWriteHTMLReport(t *testing.T) {
+	entries := []TReportEntry{
+		{Path: "x/PROJECT/525x300.jpg", ProjectDir: "x/PROJECT", Hash: "./525x300.jpg", Status: StatusOk,
+			Season: -1, Episode: -1, Size: 300 * kb, Limit: 350 * kb, Q: -1},
+		{Path: "x/PROJECT/810x498.jpg", ProjectDir: "x/PROJECT", Hash: "./810x498.jpg", Status: StatusReduced,
+			Season: 1, Episode: -1, Size: 400 * kb, Limit: 350 * kb, Q: 3, Stage: StageLossy, Params: "q:3 4:4:4"},
+		{Path: "x/OTHER/<b>.jpg", Status: StatusError, Season: -1, Episode: -1, Size: -1, Limit: -1, Q: -1,
+			Message: `<script>alert("x")</script>`, Suggestions: []string{"x/OTHER/525x300.jpg"}},
+		{Path: "x/OTHER/600x600.jpg", ProjectDir: "x/OTHER", Hash: "./600x600.jpg", Status: StatusWarning,
+			Season: -1, Episode: -1, Size: -1, Limit: -1, Q: -1, Warnings: []string{"a & b"}},
+	}
+	buf := &bytes.Buffer{}
+	if err := WriteHTMLReport(buf, entries); err != nil {
+		t.Fatalf("WriteHTMLReport() error: %v", err)
+	}
+	out := buf.String()
+
+	for _, v := range []string{
+		`<span class="badge ok">ok</span>`,
+		`<span class="badge reduced">reduced</span> s1 lossy: q:3 4:4:4`,
+		`<span class="badge error">error</span>`,
+		`<span class="badge warning">warning</span>`,
+		`class="over"`,
+		`&lt;script&gt;alert(&#34;x&#34;)&lt;/script&gt;`,
+		`&lt;b&gt;.jpg`,
+		`a &amp; b`,
+		`<div class="hint">x/OTHER/525x300.jpg</div>`,
+		`missing: ./1006x1452.jpg, ./1620x996.jpg, ./270x390.jpg, ./350x500.jpg`,
+		`<h2>x/OTHER</h2>`,
+		`<h2>x/PROJECT</h2>`,
+	} {
+		if !strings.Contains(out, v) {
+			t.Errorf("WriteHTMLReport() has no %q", v)
+		}
+	}
+	for _, v := range []string{"<script>", "<b>"} {
+		if strings.Contains(out, v) {
+			t.Errorf("WriteHTMLReport() has unescaped %q", v)
+		}
+	}
+	if strings.Index(out, "<h2>x/OTHER</h2>") > strings.Index(out, "<h2>x/PROJECT</h2>") {
+		t.Errorf("WriteHTMLReport() projects are not sorted")
+	}
+}
@@ -0,0 +1,143 @@
+package rtimg
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// TRuleTemplate - a group of <key>s that differ only in size and extension. It
+// expands to "./<prefix><size><ext>" for every size and every extension.
+type TRuleTemplate struct {
+	Profile string `json:"profile"`
+	Type    string `json:"type"`
+	// a subdirectory of the project with a trailing slash ("" - the project itself)
+	Prefix string `json:"prefix"`
+	// base names of files without extensions ("350x500", "logo", "g_iconic_poster_600x600")
+	Sizes []string `json:"sizes"`
+	// extension -> size limit in bytes (-1 - no limit)
+	Exts map[string]int64 `json:"exts"`
+	// kind of a finding -> severity (see severityOf)
+	Severity map[string]string `json:"severity"`
+	// what the JPEG optimizer must not use
+	JPEG TJPEGConstraints `json:"jpeg"`
+	// what PNG strategies may do
+	PNG TPNGConstraints `json:"png"`
+	// safe zones and UI overlays (see ZonesFor)
+	Zones []TZone `json:"zones"`
+}
+
+// TRule - an expanded <key> of the table.
+type TRule struct {
+	Hash string
+	TKeyData
+}
+
+var rtSizes = []string{"350x500", "525x300", "810x498", "270x390", "1620x996", "1006x1452"} // "503x726"
+var gpSizes = []string{"600x600", "600x840", "1920x1080", "1920x1080_left", "1920x1080_center", "1260x400", "1080x540"}
+var megafonSizes = []string{"1080x810", "1080x1232", "1104x624", "3840x1344"}
+var gafLogoSizes = []string{"g_hasLogo_600x600", "g_hasTitle_logo_1800x1000"}
+var gafPosterSizes = []string{
+	"g_iconic_poster_600x600", "g_iconic_poster_600x800", "g_iconic_poster_800x600",
+	"g_iconic_poster_1000x1500", "g_iconic_poster_3840x2160",
+	"g_iconic_background_1000x1500", "g_iconic_background_3840x2160",
+}
+
+// transparent or flat logos may have uniform borders
+var logoSeverity = map[string]string{FindingBorder: SeverityOff}
+
+var defaultRuleTemplates = []TRuleTemplate{
+	{Profile: "rt", Type: "rt", Sizes: rtSizes, Exts: map[string]int64{".jpg": 900 * kb, ".psd": none}},
+	{Profile: "rt", Type: "rt", Sizes: []string{"logo"}, Exts: map[string]int64{".png": 900 * kb, ".psd": none}, Severity: logoSeverity},
+
+	{Profile: "gp", Type: "gp", Sizes: gpSizes, Exts: map[string]int64{".jpg": 700 * kb, ".psd": none}},
+
+	{Profile: "megafon", Type: "gp", Sizes: megafonSizes, Exts: map[string]int64{".png": 6 * mb, ".psd": none}},
+
+	{Profile: "google_apple_feed", Type: "gp", Prefix: "google_apple_feed/jpg/", Sizes: gafLogoSizes, Exts: map[string]int64{".png": none}, Severity: logoSeverity},
+	{Profile: "google_apple_feed", Type: "gp", Prefix: "google_apple_feed/psd/", Sizes: gafLogoSizes, Exts: map[string]int64{".psd": none}},
+	{Profile: "google_apple_feed", Type: "gp", Prefix: "google_apple_feed/jpg/", Sizes: gafPosterSizes, Exts: map[string]int64{".jpg": 3 * mb}},
+	{Profile: "google_apple_feed", Type: "gp", Prefix: "google_apple_feed/psd/", Sizes: gafPosterSizes, Exts: map[string]int64{".psd": none}},
+}
+
+var ruleTemplates = defaultRuleTemplates
+
+// checkTemplates validates templates. Every expanded <key> must be a valid one.
+func checkTemplates(list []TRuleTemplate) error {
+	for i, v := range list {
+		if v.Profile == "" || v.Type == "" {
+			return fmt.Errorf("rule %v: profile and type must be set", i)
+		}
+		if len(v.Sizes) == 0 || len(v.Exts) == 0 {
+			return fmt.Errorf("rule %v (%v): sizes and exts must not be empty", i, v.Profile)
+		}
+		if v.Prefix != "" && (!strings.HasSuffix(v.Prefix, "/") || strings.HasPrefix(v.Prefix, "/") || strings.Contains(v.Prefix, "..")) {
+			return fmt.Errorf("rule %v (%v): invalid prefix %q", i, v.Profile, v.Prefix)
+		}
+		if err := checkSeverities(v.Severity); err != nil {
+			return fmt.Errorf("rule %v (%v): %v", i, v.Profile, err)
+		}
+		if err := checkZones(v.Zones); err != nil {
+			return fmt.Errorf("rule %v (%v): %v", i, v.Profile, err)
+		}
+		for ext := range v.Exts {
+			if !strings.HasPrefix(ext, ".") || strings.ContainsAny(ext[1:], `./\`) {
+				return fmt.Errorf("rule %v (%v): invalid extension %q", i, v.Profile, ext)
+			}
+		}
+		for _, size := range v.Sizes {
+			if size == "" || strings.ContainsAny(size, `/\`) {
+				return fmt.Errorf("rule %v (%v): invalid size %q", i, v.Profile, size)
+			}
+			for ext := range v.Exts {
+				if _, err := newKey(size+ext, ""); err != nil {
+					return fmt.Errorf("rule %v (%v): %q is not a valid <key>", i, v.Profile, size+ext)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// expandTemplates makes a table of <key>s. Later templates override earlier ones.
+func expandTemplates(list []TRuleTemplate) map[string]*TKeyData {
+	table := map[string]*TKeyData{}
+	for _, v := range list {
+		for _, size := range v.Sizes {
+			for ext, limit := range v.Exts {
+				table["./"+v.Prefix+size+ext] = &TKeyData{
+					Type:          v.Type,
+					FileSizeLimit: limit,
+					Profile:       v.Profile,
+					Severity:      v.Severity,
+					JPEG:          v.JPEG,
+					PNG:           v.PNG,
+					Zones:         v.Zones,
+				}
+			}
+		}
+	}
+	return table
+}
+
+// Rules returns <key>s of active profiles sorted by profile and hash.
+func Rules() []TRule {
+	ret := []TRule{}
+	for k, v := range postersTable {
+		if !isProfileActive(v.Profile) {
+			continue
+		}
+		ret = append(ret, TRule{k, *v})
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Profile != ret[j].Profile {
+			return ret[i].Profile < ret[j].Profile
+		}
+		if filepath.Dir(ret[i].Hash) != filepath.Dir(ret[j].Hash) {
+			return filepath.Dir(ret[i].Hash) < filepath.Dir(ret[j].Hash)
+		}
+		return ret[i].Hash < ret[j].Hash
+	})
+	return ret
+}
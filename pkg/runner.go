@@ -0,0 +1,22 @@
+package rtimg
+
+import (
+	"os/exec"
+)
+
+// ICommandRunner runs an external tool (ffmpeg, pngquant, exiftool) in the directory
+// ("" - the current one) and returns its combined stdout and stderr.
+type ICommandRunner interface {
+	Run(dir, name string, args ...string) ([]byte, error)
+}
+
+// CommandRunner is used to run all external tools. Tests replace it with fakes.
+var CommandRunner ICommandRunner = tExecRunner{}
+
+type tExecRunner struct{}
+
+func (tExecRunner) Run(dir, name string, args ...string) ([]byte, error) {
+	cmd := exec.Command(name, args...)
+	cmd.Dir = dir
+	return cmd.CombinedOutput()
+}
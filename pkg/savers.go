@@ -1,172 +1,405 @@
 package rtimg
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"image/png"
+	"io/fs"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
-	"strings"
 )
 
 func GetFileSize(filename string) (int64, error) {
-	info, err := os.Stat(filename)
+	info, err := fs.Stat(DirFS(filename))
 	if err != nil {
 		return -1, err
 	}
 	return info.Size(), nil
 }
 
-func ReduceJPG(nameIn, nameOut string, limitSize int64) (int64, int, error) {
-	q := 0
+// TJPEGConstraints - what the JPEG optimizer must not use for a <key>. The zero
+// value allows everything.
+type TJPEGConstraints struct {
+	// progressive encoding is forbidden
+	Baseline bool `json:"baseline"`
+	// chroma subsampling (4:2:0) is forbidden
+	NoSubsampling bool `json:"no_subsampling"`
+	// optimized Huffman tables are forbidden (it forbids progressive encoding too,
+	// which always uses optimized tables)
+	StdHuffman bool `json:"std_huffman"`
+}
+
+// TJPEGParams - parameters of a JPEG encoding.
+type TJPEGParams struct {
+	// ffmpeg -q:v (0 - the best)
+	Q int
+	// "4:4:4" or "4:2:0"
+	Subsampling      string
+	Progressive      bool
+	OptimizedHuffman bool
+}
+
+func (o TJPEGParams) String() string {
+	ret := fmt.Sprintf("q:%v %v", o.Q, o.Subsampling)
+	if o.Progressive {
+		ret += " progressive"
+	}
+	if o.OptimizedHuffman {
+		ret += " optimized-huffman"
+	}
+	return ret
+}
+
+var jpegPixFmts = map[string]string{"4:4:4": "yuvj444p", "4:2:0": "yuvj420p"}
+
+// jpegCandidates returns encodings allowed by the constraints from the best quality
+// to the worst one: a lower q is better, 4:4:4 is better than 4:2:0 with the same q.
+// Huffman optimization is lossless, so it is used whenever it is allowed.
+func jpegCandidates(c TJPEGConstraints) []TJPEGParams {
+	subsamplings := []string{"4:4:4", "4:2:0"}
+	if c.NoSubsampling {
+		subsamplings = subsamplings[:1]
+	}
+	ret := []TJPEGParams{}
+	for q := 0; q <= 31; q++ {
+		for _, v := range subsamplings {
+			ret = append(ret, TJPEGParams{Q: q, Subsampling: v, OptimizedHuffman: !c.StdHuffman})
+		}
+	}
+	return ret
+}
+
+// ReduceJPG encodes the file with the best quality parameters that fit the limit.
+// Every candidate is also rewritten as a progressive one (a lossless rewrite) if the
+// constraints allow it, so a candidate that is too large as a baseline one may fit.
+// jpegtran is optional: if it is not installed or fails, baseline candidates are used.
+func ReduceJPG(nameIn, nameOut string, limitSize int64, c TJPEGConstraints) (int64, TJPEGParams, error) {
+	useProgressive := !c.Baseline && !c.StdHuffman
 	outputSize := int64(-1)
-	for q <= 31 {
-		// Run ffmpeg to encode file to JPEG.
-		stdoutStderr, err := exec.Command("ffmpeg",
-			"-i", nameIn,
-			"-q:v", fmt.Sprintf("%v", q),
-			"-pix_fmt", "rgb24",
-			"-map_metadata", "-1",
-			"-loglevel", "error",
-			"-y",
-			nameOut,
-		).CombinedOutput()
+	for _, params := range jpegCandidates(c) {
+		err := encodeJPG(nameIn, nameOut, params)
 		if err != nil {
-			return -1, -1, err
-		}
-		if len(stdoutStderr) > 0 {
-			return -1, -1, fmt.Errorf("%v", stdoutStderr)
+			return -1, TJPEGParams{}, err
 		}
-
 		outputSize, err = GetFileSize(nameOut)
 		if err != nil {
-			return -1, -1, err
+			return -1, TJPEGParams{}, err
+		}
+		if useProgressive {
+			progressive := nameOut + ".progressive.jpg"
+			size, err := jpegTran(nameOut, progressive, true)
+			if err != nil || size < 0 {
+				useProgressive = false
+			} else if size < outputSize {
+				if err := os.Rename(progressive, nameOut); err != nil {
+					os.Remove(progressive)
+					return -1, TJPEGParams{}, err
+				}
+				outputSize = size
+				params.Progressive = true
+			}
+			os.Remove(progressive)
 		}
 		if outputSize <= limitSize {
-			return outputSize, q, nil
+			return outputSize, params, nil
 		}
-		q++
 	}
-	return -1, -1, fmt.Errorf("cannot reduce file size (%v>%v)", outputSize, limitSize)
+	return -1, TJPEGParams{}, fmt.Errorf("cannot reduce file size: %w", &TSizeError{outputSize, limitSize})
 }
 
-func ReducePNG(nameIn, nameOut string, limitSize int64) (int64, int, error) {
-	err := pngQuant(nameIn, nameOut)
+func encodeJPG(nameIn, nameOut string, params TJPEGParams) error {
+	huffman := "default"
+	if params.OptimizedHuffman {
+		huffman = "optimal"
+	}
+	// Run ffmpeg to encode file to JPEG.
+	stdoutStderr, err := CommandRunner.Run("", "ffmpeg",
+		"-i", nameIn,
+		"-q:v", fmt.Sprintf("%v", params.Q),
+		"-pix_fmt", jpegPixFmts[params.Subsampling],
+		"-huffman", huffman,
+		"-map_metadata", "-1",
+		"-loglevel", "error",
+		"-y",
+		nameOut,
+	)
 	if err != nil {
-		// Run ffmpeg to encode file to PNG.
-		stdoutStderr, err := exec.Command("ffmpeg",
+		return &TToolError{Tool: "ffmpeg", Output: string(stdoutStderr), Err: err}
+	}
+	if len(stdoutStderr) > 0 {
+		return &TToolError{Tool: "ffmpeg", Output: string(stdoutStderr)}
+	}
+	return nil
+}
+
+// jpegTran rewrites the JPEG keeping its pixels with optimized Huffman tables and,
+// if it is set, progressive encoding. It returns the size of the output or -1 if
+// jpegtran is not installed.
+func jpegTran(nameIn, nameOut string, progressive bool) (int64, error) {
+	args := []string{"-copy", "none", "-optimize"}
+	if progressive {
+		args = append(args, "-progressive")
+	}
+	stdoutStderr, err := CommandRunner.Run("", "jpegtran", append(args, "-outfile", nameOut, nameIn)...)
+	if errors.Is(err, exec.ErrNotFound) {
+		return -1, nil
+	}
+	if err != nil || len(stdoutStderr) > 0 {
+		return -1, &TToolError{Tool: "jpegtran", Output: string(stdoutStderr), Err: err}
+	}
+	return GetFileSize(nameOut)
+}
+
+// ReducePNG tries the strategies in order and stops at the first result that fits
+// the limit, otherwise the error has the smallest size the strategies have given
+// (ErrNoOutput if there is none). If pngquant cannot read the file, it is converted
+// by ffmpeg and pngquant tries again.
+func ReducePNG(nameIn, nameOut string, limitSize int64, strategies []TPNGStrategy) (int64, TPNGStrategy, error) {
+	converted := nameOut + ".ffmpeg.png"
+	defer os.Remove(converted)
+	input := nameIn
+	outputSize := int64(-1)
+	for _, v := range strategies {
+		size, err := applyPNGStrategy(input, nameOut, v)
+		var toolErr *TToolError
+		if errors.As(err, &toolErr) && toolErr.Tool == "pngquant" && input == nameIn {
+			// Run ffmpeg to encode file to PNG.
+			stdoutStderr, err := CommandRunner.Run("", "ffmpeg",
+				"-i", nameIn,
+				"-q:v", "0",
+				"-map_metadata", "-1",
+				"-loglevel", "error",
+				"-y",
+				converted,
+			)
+			if len(stdoutStderr) > 0 || err != nil {
+				return -1, TPNGStrategy{}, &TToolError{Tool: "ffmpeg", Output: string(stdoutStderr), Err: err}
+			}
+			input = converted
+			// Try using pngquant again.
+			size, err = applyPNGStrategy(input, nameOut, v)
+		}
+		if err != nil {
+			return -1, TPNGStrategy{}, err
+		}
+		if size < 0 {
+			continue
+		}
+		if size <= limitSize {
+			return size, v, nil
+		}
+		if outputSize < 0 || size < outputSize {
+			outputSize = size
+		}
+	}
+	if outputSize < 0 {
+		return -1, TPNGStrategy{}, fmt.Errorf("cannot reduce file size: %w", ErrNoOutput)
+	}
+	return -1, TPNGStrategy{}, fmt.Errorf("cannot reduce file size: %w", &TSizeError{outputSize, limitSize})
+}
+
+// applyPNGStrategy writes the output and returns its size or -1 if the strategy
+// has given nothing (pngquant could not reach the quality or the output is larger).
+func applyPNGStrategy(nameIn, nameOut string, strategy TPNGStrategy) (int64, error) {
+	if strategy.Kind == PNGLossless {
+		return recompressPNG(nameIn, nameOut)
+	}
+	if strategy.Scale > 0 {
+		scaled := nameOut + ".scaled.png"
+		defer os.Remove(scaled)
+		stdoutStderr, err := CommandRunner.Run("", "ffmpeg",
 			"-i", nameIn,
-			"-q:v", "0",
+			"-vf", fmt.Sprintf("scale=iw*%v:-1", strategy.Scale),
 			"-map_metadata", "-1",
 			"-loglevel", "error",
 			"-y",
-			nameOut,
-		).CombinedOutput()
-		if len(stdoutStderr) > 0 {
-			return -1, -1, fmt.Errorf("%v", stdoutStderr)
-		}
-		if err != nil {
-			return -1, -1, err
-		}
-		// Try using pngquant again.
-		err = pngQuant(nameOut, nameOut)
-		if err != nil {
-			return -1, -1, err
+			scaled,
+		)
+		if len(stdoutStderr) > 0 || err != nil {
+			return -1, &TToolError{Tool: "ffmpeg", Output: string(stdoutStderr), Err: err}
 		}
+		nameIn = scaled
+	}
+	ok, err := pngQuant(nameIn, nameOut, strategy)
+	if err != nil || !ok {
+		return -1, err
+	}
+	return GetFileSize(nameOut)
+}
+
+// stages of ReduceImage
+const (
+	StageLossless = "lossless"
+	StageLossy    = "lossy"
+)
+
+// TReduceResult - what ReduceImage has done with a file.
+type TReduceResult struct {
+	Size int64
+	// quality of a lossy encoding (-1 - not applicable)
+	Q int
+	// parameters of the encoding ("" - the file is not re-encoded)
+	Params string
+	// the stage that fit the file into the limit ("" - the file is not re-encoded)
+	Stage string
+}
+
+// reduceLosslessJPG optimizes the file keeping its pixels: it gets optimized Huffman
+// tables and progressive encoding as far as the constraints allow. It returns the
+// size of the output and its parameters or -1 if the stage is skipped (jpegtran is
+// not installed or nothing is allowed).
+func reduceLosslessJPG(nameIn, nameOut string, c TJPEGConstraints) (int64, string, error) {
+	if c.StdHuffman {
+		return -1, "", nil
+	}
+	params := "optimized-huffman"
+	if !c.Baseline {
+		params += " progressive"
+	}
+	size, err := jpegTran(nameIn, nameOut, !c.Baseline)
+	if err != nil || size < 0 {
+		return -1, "", err
+	}
+	return size, params, nil
+}
+
+// recompressPNG writes the PNG with the best compression keeping its pixels. It
+// returns -1 if the file cannot be decoded (pngquant reports it then).
+func recompressPNG(nameIn, nameOut string) (int64, error) {
+	data, err := ioutil.ReadFile(nameIn)
+	if err != nil {
+		return -1, err
 	}
-	outputSize, err := GetFileSize(nameOut)
+	img, err := png.Decode(bytes.NewReader(data))
 	if err != nil {
-		return -1, -1, err
+		return -1, nil
+	}
+	buf := &bytes.Buffer{}
+	encoder := &png.Encoder{CompressionLevel: png.BestCompression}
+	if err := encoder.Encode(buf, img); err != nil {
+		return -1, err
 	}
-	if outputSize > limitSize {
-		return -1, -1, fmt.Errorf("cannot reduce file size (%v>%v)", outputSize, limitSize)
+	if err := ioutil.WriteFile(nameOut, buf.Bytes(), 0644); err != nil {
+		return -1, err
 	}
-	return outputSize, -1, nil
+	return int64(buf.Len()), nil
 }
 
-// ReduceImage - returns outputSize, q, error
-func ReduceImage(filePath string, sizeLimit int64) (int64, int, error) {
+// ReduceImage strips metadata of the file and, if it is still too large, optimizes
+// it losslessly and then re-encodes it lossily until it fits the size limit. It uses
+// the constraints of the <key> data (nil - no constraints).
+func ReduceImage(filePath string, sizeLimit int64, data *TKeyData) (*TReduceResult, error) {
 	err := exifTool(filePath)
 	if err != nil {
-		return -1, -1, err
+		return nil, err
 	}
 
 	inputSize, err := GetFileSize(filePath)
 	if err != nil {
-		return -1, -1, err
+		return nil, err
 	}
 
 	if inputSize <= sizeLimit || sizeLimit < 0 {
 		// PrintGreen(fileName, "Ok")
-		return inputSize, -1, nil
+		return &TReduceResult{Size: inputSize, Q: -1}, nil
 	}
 
 	nameIn := filePath
-	nameOut := ""
-	outputSize := int64(-1)
-	q := -1
+	ext := NormalizeExt(filepath.Ext(nameIn))
+	nameOut := filePath + "####" + ext
+	if data == nil {
+		data = &TKeyData{}
+	}
+	ret := &TReduceResult{Size: -1, Q: -1, Stage: StageLossless}
 
-	ext := strings.ToLower(filepath.Ext(nameIn))
 	switch ext {
 	default:
-		return -1, -1, fmt.Errorf("unsupported extension [%q] to process file", ext)
+		return nil, fmt.Errorf("%w %q to process file", ErrUnsupportedExtension, ext)
 	case ".jpg":
-		nameOut = filePath + "####.jpg"
-		outputSize, q, err = ReduceJPG(nameIn, nameOut, sizeLimit)
+		ret.Size, ret.Params, err = reduceLosslessJPG(nameIn, nameOut, data.JPEG)
+		// jpegtran is optional, so its failure falls through to the lossy stage
+		if err != nil || ret.Size < 0 || ret.Size > sizeLimit {
+			ret.Stage = StageLossy
+			params := TJPEGParams{}
+			ret.Size, params, err = ReduceJPG(nameIn, nameOut, sizeLimit, data.JPEG)
+			ret.Q, ret.Params = params.Q, params.String()
+		}
 	case ".png":
-		nameOut = filePath + "####.png"
-		outputSize, q, err = ReducePNG(nameIn, nameOut, sizeLimit)
+		strategy := TPNGStrategy{}
+		ret.Size, strategy, err = ReducePNG(nameIn, nameOut, sizeLimit, pngStrategiesFor(data.PNG))
+		ret.Params = strategy.String()
+		if strategy.Kind != PNGLossless {
+			ret.Stage = StageLossy
+		}
 	}
 	if err != nil {
-		// !!!FIXME: it's not good behavior to skip error checks
-		err := os.Remove(nameOut)
-		_ = err
-		return -1, -1, err
+		// the output may not have been created
+		os.Remove(nameOut)
+		return nil, err
 	}
 
 	err = os.Rename(nameOut, nameIn)
 	if err != nil {
-		return -1, -1, err
+		return nil, err
 	}
 
-	return outputSize, q, nil
+	return ret, nil
 }
 
-// pngQuant reduces the file size of input PNG file with lossy compression.
-func pngQuant(filePath string, output string) error {
-	// Run pngquant to reduce the file size of input PNG file with lossy compression.
-	stdoutStderr, err := exec.Command("pngquant",
+// pngQuant reduces the file size of input PNG file with lossy compression. It
+// returns false if pngquant has written nothing because it could not reach the
+// minimum quality or the result is larger than the input.
+func pngQuant(filePath string, output string, strategy TPNGStrategy) (bool, error) {
+	quality := strategy.Quality
+	if quality == "" {
+		quality = "0-100"
+	}
+	args := []string{
 		"--force",
 		"--skip-if-larger",
 		"--output", output,
-		"--quality=0-100",
+		"--quality=" + quality,
 		"--speed", "1",
 		"--strip",
-		"--", filePath,
-	).CombinedOutput()
-	if len(stdoutStderr) > 0 {
-		return fmt.Errorf("%q", string(stdoutStderr))
 	}
-	if err != nil {
-		return err
+	if strategy.NoDither {
+		args = append(args, "--nofs")
 	}
-	return nil
+	if strategy.Colors > 0 {
+		args = append(args, fmt.Sprintf("%v", strategy.Colors))
+	}
+	// Run pngquant to reduce the file size of input PNG file with lossy compression.
+	stdoutStderr, err := CommandRunner.Run("", "pngquant", append(args, "--", filePath)...)
+	var exitErr interface{ ExitCode() int }
+	if errors.As(err, &exitErr) && (exitErr.ExitCode() == pngQuantSkipped || exitErr.ExitCode() == pngQuantQualityTooLow) {
+		return false, nil
+	}
+	if len(stdoutStderr) > 0 || err != nil {
+		return false, &TToolError{Tool: "pngquant", Output: string(stdoutStderr), Err: err}
+	}
+	return true, nil
 }
 
+// exit codes of pngquant
+const (
+	pngQuantSkipped       = 98
+	pngQuantQualityTooLow = 99
+)
+
 func exifTool(filePath string) error {
 	path, name := filepath.Split(filePath)
 
 	// Run pngquant to reduce the file size of input PNG file with lossy compression.
-	cmd := exec.Command("exiftool",
+	stdoutStderr, err := CommandRunner.Run(path, "exiftool",
 		"-charset filename=UTF8",
 		"-overwrite_original",
 		"-all=", name,
 	)
-	cmd.Dir = path
-
-	stdoutStderr, err := cmd.CombinedOutput()
 	if err != nil {
-		// return err
-		return fmt.Errorf("error: %s\ndata:\n%q", err.Error(), string(stdoutStderr))
+		return &TToolError{Tool: "exiftool", Output: string(stdoutStderr), Err: err}
 	}
 	return nil
 }
@@ -0,0 +1,287 @@
+package rtimg
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// tScriptedRunner - tools that write outputs of 1000-20*q bytes (ffmpeg, 100 bytes
+// less for 4:2:0, the input scaled by the square of the factor for -vf), of 90% of
+// the input (jpegtran) or of the input * max quality/200 * colors/256 (pngquant,
+// it exits with 99 if the min quality is above reach), or fail or are not
+// installed if it is set.
+type tScriptedRunner struct {
+	fail    map[string]bool
+	missing map[string]bool
+	reach   int
+	calls   []string
+}
+
+type tExitError int
+
+func (o tExitError) Error() string {
+	return fmt.Sprintf("exit status %v", int(o))
+}
+
+func (o tExitError) ExitCode() int {
+	return int(o)
+}
+
+func (o *tScriptedRunner) Run(dir, name string, args ...string) ([]byte, error) {
+	if o.missing[name] {
+		return nil, &exec.Error{Name: name, Err: exec.ErrNotFound}
+	}
+	o.calls = append(o.calls, name)
+	if o.fail[name] {
+		return []byte(name + " failed"), fmt.Errorf("exit status 1")
+	}
+	switch name {
+	case "ffmpeg":
+		size := 1000
+		for i := range args[:len(args)-1] {
+			switch {
+			case args[i] == "-vf":
+				data, err := ioutil.ReadFile(args[1])
+				if err != nil {
+					return nil, err
+				}
+				scale, _ := strconv.ParseFloat(strings.TrimSuffix(strings.TrimPrefix(args[i+1], "scale=iw*"), ":-1"), 64)
+				size = int(float64(len(data)) * scale * scale)
+			case args[i] == "-q:v":
+				q, _ := strconv.Atoi(args[i+1])
+				size -= 20 * q
+			case args[i] == "-pix_fmt" && args[i+1] == "yuvj420p":
+				size -= 100
+			}
+		}
+		return nil, ioutil.WriteFile(args[len(args)-1], bytes.Repeat([]byte{0}, size), 0644)
+	case "jpegtran":
+		data, err := ioutil.ReadFile(args[len(args)-1])
+		if err != nil {
+			return nil, err
+		}
+		return nil, ioutil.WriteFile(args[len(args)-2], data[:len(data)*9/10], 0644)
+	case "pngquant":
+		data, err := ioutil.ReadFile(args[len(args)-1])
+		if err != nil {
+			return nil, err
+		}
+		min, max, colors := 0, 100, 256
+		for i, v := range args {
+			if strings.HasPrefix(v, "--quality=") {
+				fmt.Sscanf(v, "--quality=%d-%d", &min, &max)
+			}
+			// the number of colors is the only numeric argument except the speed
+			if n, err := strconv.Atoi(v); err == nil && args[i-1] != "--speed" {
+				colors = n
+			}
+		}
+		if o.reach > 0 && min > o.reach {
+			return nil, tExitError(99)
+		}
+		return nil, ioutil.WriteFile(args[3], data[:len(data)*max/200*colors/256], 0644)
+	}
+	return nil, nil
+}
+
+// TestReduceImage -
+func TestReduceImage(t *testing.T) {
+	defer func(runner ICommandRunner) {
+		CommandRunner = runner
+	}(CommandRunner)
+	dir := t.TempDir()
+
+	baseline := &TKeyData{JPEG: TJPEGConstraints{Baseline: true, NoSubsampling: true}}
+	stdHuffman := &TKeyData{JPEG: TJPEGConstraints{StdHuffman: true}}
+	table := []struct {
+		name    string
+		limit   int64
+		data    *TKeyData
+		fail    map[string]bool
+		missing map[string]bool
+		reach   int
+		want    TReduceResult
+		err     error
+	}{
+		{name: "a.jpg", limit: 2000, want: TReduceResult{Size: 1000, Q: -1}},
+		{name: "a.jpg", limit: -1, want: TReduceResult{Size: 1000, Q: -1}},
+		{name: "a.jpg", limit: 950, want: TReduceResult{Size: 900, Q: -1, Params: "optimized-huffman progressive", Stage: StageLossless}},
+		{name: "a.jpg", limit: 950, data: baseline, want: TReduceResult{Size: 900, Q: -1, Params: "optimized-huffman", Stage: StageLossless}},
+		{name: "a.jpg", limit: 950, data: stdHuffman, want: TReduceResult{Size: 900, Q: 0, Params: "q:0 4:2:0", Stage: StageLossy}},
+		{name: "a.jpg", limit: 650, want: TReduceResult{Size: 648, Q: 9, Params: "q:9 4:2:0 progressive optimized-huffman", Stage: StageLossy}},
+		{name: "a.jpg", limit: 650, missing: map[string]bool{"jpegtran": true}, want: TReduceResult{Size: 640, Q: 13, Params: "q:13 4:2:0 optimized-huffman", Stage: StageLossy}},
+		{name: "a.jpg", limit: 650, data: baseline, want: TReduceResult{Size: 640, Q: 18, Params: "q:18 4:4:4 optimized-huffman", Stage: StageLossy}},
+		{name: "a.jpg", limit: 650, data: stdHuffman, want: TReduceResult{Size: 640, Q: 13, Params: "q:13 4:2:0", Stage: StageLossy}},
+		{name: "a.jpg", limit: 10, err: ErrTooLarge},
+		{name: "a.jpg", limit: 650, fail: map[string]bool{"ffmpeg": true}, err: ErrToolFailure},
+		{name: "a.jpg", limit: 650, fail: map[string]bool{"jpegtran": true}, want: TReduceResult{Size: 640, Q: 13, Params: "q:13 4:2:0 optimized-huffman", Stage: StageLossy}},
+		{name: "a.jpg", limit: 10, fail: map[string]bool{"jpegtran": true}, err: ErrTooLarge},
+		{name: "a.jpg", limit: 650, fail: map[string]bool{"exiftool": true}, err: ErrToolFailure},
+		{name: "a.png", limit: 650, want: TReduceResult{Size: 500, Q: -1, Params: "pngquant quality:90-100", Stage: StageLossy}},
+		{name: "a.png", limit: 450, want: TReduceResult{Size: 425, Q: -1, Params: "pngquant quality:60-85", Stage: StageLossy}},
+		{name: "a.png", limit: 450, reach: 50, want: TReduceResult{Size: 350, Q: -1, Params: "pngquant quality:40-70", Stage: StageLossy}},
+		{name: "a.png", limit: 200, want: TReduceResult{Size: 125, Q: -1, Params: "pngquant quality:0-100 colors:64 no-dither", Stage: StageLossy}},
+		{name: "a.png", limit: 100, err: ErrTooLarge},
+		{name: "a.gif", limit: 650, err: ErrUnsupportedExtension},
+	}
+	for _, v := range table {
+		path := filepath.Join(dir, v.name)
+		if err := ioutil.WriteFile(path, bytes.Repeat([]byte{1}, 1000), 0644); err != nil {
+			t.Fatal(err)
+		}
+		runner := &tScriptedRunner{fail: v.fail, missing: v.missing, reach: v.reach}
+		CommandRunner = runner
+		res, err := ReduceImage(path, v.limit, v.data)
+		got := TReduceResult{}
+		if res != nil {
+			got = *res
+		}
+		if got != v.want || !errors.Is(err, v.err) {
+			t.Errorf("ReduceImage(%v, %v, %v) = %v, %v, want %v, %v (calls %v)",
+				v.name, v.limit, v.data, got, err, v.want, v.err, runner.calls)
+		}
+	}
+
+	// jpegtran runs once for the lossless stage and once for every tried candidate
+	path := filepath.Join(dir, "b.jpg")
+	if err := ioutil.WriteFile(path, bytes.Repeat([]byte{1}, 1000), 0644); err != nil {
+		t.Fatal(err)
+	}
+	runner := &tScriptedRunner{}
+	CommandRunner = runner
+	calls := func(name string) int {
+		n := 0
+		for _, v := range runner.calls {
+			if v == name {
+				n++
+			}
+		}
+		return n
+	}
+	if _, err := ReduceImage(path, 650, nil); err != nil || calls("jpegtran") != calls("ffmpeg")+1 {
+		t.Errorf("ReduceImage(%v) error: %v (calls %v)", path, err, runner.calls)
+	}
+
+	// an uncompressed PNG fits after the lossless stage without tools
+	path = filepath.Join(dir, "c.png")
+	buf := &bytes.Buffer{}
+	encoder := &png.Encoder{CompressionLevel: png.NoCompression}
+	if err := encoder.Encode(buf, gradient(200, 200, false, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	runner = &tScriptedRunner{}
+	CommandRunner = runner
+	res, err := ReduceImage(path, int64(buf.Len()/2), nil)
+	if err != nil || res.Stage != StageLossless || len(runner.calls) != 1 {
+		t.Errorf("ReduceImage(%v) = %v, %v (calls %v)", path, res, err, runner.calls)
+	}
+	if img, err := decodePNGFile(path); err != nil || img.Bounds().Dx() != 200 {
+		t.Errorf("%v: %v", path, err)
+	}
+
+	// pngquant falls back to ffmpeg and then tries again
+	path = filepath.Join(dir, "b.png")
+	if err := ioutil.WriteFile(path, bytes.Repeat([]byte{1}, 1000), 0644); err != nil {
+		t.Fatal(err)
+	}
+	runner = &tScriptedRunner{}
+	CommandRunner = runner
+	if _, _, err := ReducePNG(path, path+".out.png", 1000, defaultPNGStrategies); err != nil {
+		t.Errorf("ReducePNG() error: %v", err)
+	}
+	runner = &tScriptedRunner{fail: map[string]bool{"pngquant": true}}
+	CommandRunner = runner
+	if _, _, err := ReducePNG(path, path+".out.png", 1000, defaultPNGStrategies); !errors.Is(err, ErrToolFailure) || len(runner.calls) != 3 {
+		t.Errorf("ReducePNG() error: %v (calls %v)", err, runner.calls)
+	}
+}
+
+// TestPNGStrategies -
+func TestPNGStrategies(t *testing.T) {
+	defer func(runner ICommandRunner, list []TPNGStrategy) {
+		CommandRunner = runner
+		pngStrategies = list
+	}(CommandRunner, pngStrategies)
+
+	if err := checkPNGStrategies(defaultPNGStrategies); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(pngStrategiesFor(TPNGConstraints{})); n != len(defaultPNGStrategies)-2 {
+		t.Errorf("pngStrategiesFor() returned %v strategies with downscale forbidden", n)
+	}
+	if n := len(pngStrategiesFor(TPNGConstraints{AllowDownscale: true})); n != len(defaultPNGStrategies) {
+		t.Errorf("pngStrategiesFor() returned %v strategies with downscale allowed", n)
+	}
+
+	// downscale only if the <key> allows it
+	pngStrategies = []TPNGStrategy{{Kind: PNGQuant}, {Kind: PNGQuant, Scale: 0.5}}
+	path := filepath.Join(t.TempDir(), "a.png")
+	for _, allow := range []bool{false, true} {
+		if err := ioutil.WriteFile(path, bytes.Repeat([]byte{1}, 1000), 0644); err != nil {
+			t.Fatal(err)
+		}
+		runner := &tScriptedRunner{}
+		CommandRunner = runner
+		res, err := ReduceImage(path, 200, &TKeyData{PNG: TPNGConstraints{AllowDownscale: allow}})
+		switch {
+		case !allow && !errors.Is(err, ErrTooLarge),
+			allow && (err != nil || res.Size != 125 || res.Params != "pngquant scale:0.5"):
+			t.Errorf("ReduceImage(allow downscale %v) = %v, %v (calls %v)", allow, res, err, runner.calls)
+		}
+	}
+
+	// the error has the smallest output or there is no output at all
+	pngStrategies = []TPNGStrategy{{Kind: PNGQuant, Quality: "90-100", Colors: 128}, {Kind: PNGQuant, Quality: "60-100"}}
+	for _, reach := range []int{0, 50} {
+		if err := ioutil.WriteFile(path, bytes.Repeat([]byte{1}, 1000), 0644); err != nil {
+			t.Fatal(err)
+		}
+		CommandRunner = &tScriptedRunner{reach: reach}
+		_, err := ReduceImage(path, 100, nil)
+		var sizeErr *TSizeError
+		switch {
+		case reach == 0 && !(errors.As(err, &sizeErr) && sizeErr.Size == 250),
+			reach == 50 && !errors.Is(err, ErrNoOutput):
+			t.Errorf("ReduceImage(reach %v) error: %v", reach, err)
+		}
+	}
+
+	config := &TConfig{PNGStrategies: []TPNGStrategy{{Kind: PNGQuant, Quality: "50-100", Colors: 16}}}
+	if err := config.Apply(); err != nil || len(pngStrategies) != 1 {
+		t.Errorf("Apply() = %v, strategies %v", err, pngStrategies)
+	}
+	for _, v := range [][]TPNGStrategy{
+		{},
+		{{Kind: "optipng"}},
+		{{Kind: PNGLossless, Colors: 16}},
+		{{Kind: PNGQuant, Quality: "100-50"}},
+		{{Kind: PNGQuant, Quality: "high"}},
+		{{Kind: PNGQuant, Colors: 1000}},
+		{{Kind: PNGQuant, Scale: 1.5}},
+	} {
+		config := &TConfig{PNGStrategies: v}
+		if err := config.Apply(); err == nil {
+			t.Errorf("Apply(%v) must fail", v)
+		}
+	}
+}
+
+func decodePNGFile(path string) (image.Image, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return png.Decode(bytes.NewReader(data))
+}
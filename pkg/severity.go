@@ -0,0 +1,113 @@
+package rtimg
+
+import (
+	"fmt"
+	"strings"
+)
+
+// severities of findings
+const (
+	SeverityInfo    = "info"
+	SeverityWarning = "warning"
+	SeverityError   = "error"
+	// the finding is dropped (and not looked for where it is expensive)
+	SeverityOff = "off"
+)
+
+// kinds of findings
+const (
+	FindingNameMismatch = "name-mismatch"
+	FindingUniform      = "uniform"
+	FindingLowEntropy   = "low-entropy"
+	FindingBorder       = "border"
+)
+
+// TFinding - a result of a check that is not fatal by itself. Its severity depends
+// on the <key> (see severityOf).
+type TFinding struct {
+	Kind     string
+	Severity string
+	Message  string
+}
+
+// Strict promotes warnings to errors.
+var Strict = false
+
+var defaultSeverities = map[string]string{
+	FindingNameMismatch: SeverityWarning,
+	FindingUniform:      SeverityWarning,
+	FindingLowEntropy:   SeverityWarning,
+	FindingBorder:       SeverityError,
+}
+
+var severities = defaultSeverities
+
+// profile -> kind -> severity
+var profileSeverities = map[string]map[string]string{}
+
+// severityOf returns the severity of the kind for the <key>: a rule overrides
+// a profile which overrides the default.
+func severityOf(kind string, data *TKeyData) string {
+	ret := severities[kind]
+	if kind == FindingNameMismatch && NameMismatchCheck == CheckError {
+		ret = SeverityError
+	}
+	if data != nil {
+		if v, ok := profileSeverities[data.Profile][kind]; ok {
+			ret = v
+		}
+		if v, ok := data.Severity[kind]; ok {
+			ret = v
+		}
+	}
+	if Strict && ret == SeverityWarning {
+		ret = SeverityError
+	}
+	return ret
+}
+
+// resolveFindings sets severities of the findings and returns error ones as an error.
+func resolveFindings(findings []TFinding, data *TKeyData) ([]TFinding, error) {
+	ret := []TFinding(nil)
+	errs := []string{}
+	for _, v := range findings {
+		v.Severity = severityOf(v.Kind, data)
+		if v.Severity == SeverityOff {
+			continue
+		}
+		if v.Severity == SeverityError {
+			errs = append(errs, v.Message)
+		}
+		ret = append(ret, v)
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("%v", strings.Join(errs, "; "))
+	}
+	return ret, nil
+}
+
+// checkSeverities validates kind -> severity map.
+func checkSeverities(list map[string]string) error {
+	for kind, severity := range list {
+		if _, ok := defaultSeverities[kind]; !ok {
+			return fmt.Errorf("severity: unknown kind %q", kind)
+		}
+		switch severity {
+		default:
+			return fmt.Errorf("severity: %v: unknown severity %q", kind, severity)
+		case SeverityInfo, SeverityWarning, SeverityError, SeverityOff:
+		}
+	}
+	return nil
+}
+
+func mergeSeverities(list, with map[string]string) map[string]string {
+	ret := map[string]string{}
+	for k, v := range list {
+		ret[k] = v
+	}
+	for k, v := range with {
+		ret[k] = v
+	}
+	return ret
+}
@@ -0,0 +1,87 @@
+package rtimg
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// kinds of structural directories of series
+const (
+	KindSeason  = "season"
+	KindEpisode = "episode"
+	KindExtras  = "extras"
+)
+
+// TStructureRule - a directory between the project directory and the <key> that
+// is not a project itself. The first group of a season or episode pattern is its number.
+type TStructureRule struct {
+	Kind    string `json:"kind"`
+	Pattern string `json:"pattern"`
+	re      *regexp.Regexp
+}
+
+var defaultStructureRules = []TStructureRule{
+	{Kind: KindSeason, Pattern: `^(\d+) сезон$`},
+	{Kind: KindSeason, Pattern: `(?i)^сезон[ _]?(\d+)$`},
+	{Kind: KindSeason, Pattern: `(?i)^season[ _]?(\d+)$`},
+	{Kind: KindSeason, Pattern: `(?i)^(?:staffel|saison|temporada|stagione)[ _]?(\d+)$`},
+	{Kind: KindSeason, Pattern: `(?i)^s(\d{1,3})$`},
+	{Kind: KindEpisode, Pattern: `^(\d+) серия$`},
+	{Kind: KindEpisode, Pattern: `(?i)^серия[ _]?(\d+)$`},
+	{Kind: KindEpisode, Pattern: `(?i)^(?:episode|ep|folge|episodio)[ _.]?(\d+)$`},
+	{Kind: KindEpisode, Pattern: `(?i)^e(\d{1,4})$`},
+	{Kind: KindExtras, Pattern: `(?i)^(extras|bonus|доп|допы|бонусы)$`},
+}
+
+var structureRules = mustCompileStructure(defaultStructureRules)
+
+func compileStructure(rules []TStructureRule) ([]TStructureRule, error) {
+	ret := make([]TStructureRule, 0, len(rules))
+	for _, v := range rules {
+		switch v.Kind {
+		default:
+			return nil, fmt.Errorf("structure: unknown kind %q", v.Kind)
+		case KindSeason, KindEpisode, KindExtras:
+		}
+		re, err := regexp.Compile(v.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("structure: %v", err)
+		}
+		if v.Kind != KindExtras && re.NumSubexp() < 1 {
+			return nil, fmt.Errorf("structure: %q must have a group with a %v number", v.Pattern, v.Kind)
+		}
+		v.re = re
+		ret = append(ret, v)
+	}
+	return ret, nil
+}
+
+func mustCompileStructure(rules []TStructureRule) []TStructureRule {
+	ret, err := compileStructure(rules)
+	if err != nil {
+		panic(err)
+	}
+	return ret
+}
+
+// matchStructure returns the rule that matches the directory name and the number
+// from the first group (-1 if there is none).
+func matchStructure(dir string) (*TStructureRule, int) {
+	dir = normalizeName(dir)
+	for i := range structureRules {
+		rule := &structureRules[i]
+		m := rule.re.FindStringSubmatch(dir)
+		if m == nil {
+			continue
+		}
+		num := -1
+		if rule.Kind != KindExtras && len(m) > 1 {
+			if n, err := strconv.Atoi(m[1]); err == nil {
+				num = n
+			}
+		}
+		return rule, num
+	}
+	return nil, -1
+}
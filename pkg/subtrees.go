@@ -0,0 +1,73 @@
+package rtimg
+
+import (
+	"fmt"
+	"strings"
+)
+
+// TSubtree - a named delivery subdirectory of a project for a partner. Its <key>s
+// are "./<dir>/<rule prefix><size><ext>" and its directory name never becomes a
+// project name.
+type TSubtree struct {
+	Name string `json:"name"`
+	Dir  string `json:"dir"`
+	// the profile of the rules is the subtree name, their prefix is relative to dir
+	Rules []TRuleTemplate `json:"rules"`
+}
+
+var viasatExts = map[string]int64{".jpg": 3 * mb, ".psd": none}
+
+var defaultSubtrees = []TSubtree{
+	{Name: "viasat", Dir: "для сервиса", Rules: []TRuleTemplate{
+		{Type: "gp", Sizes: []string{"600x600", "600x840", "1080x540", "1760x557", "1920x1080"}, Exts: viasatExts},
+	}},
+}
+
+var subtrees = defaultSubtrees
+
+// mergeSubtrees replaces subtrees with the same name and appends new ones.
+func mergeSubtrees(list, with []TSubtree) ([]TSubtree, error) {
+	ret := append([]TSubtree{}, list...)
+	for _, v := range with {
+		if v.Name == "" || v.Dir == "" || len(v.Rules) == 0 {
+			return nil, fmt.Errorf("subtree: name, dir and rules must be set (%q)", v.Name)
+		}
+		if strings.ContainsAny(v.Dir, `/\`) {
+			return nil, fmt.Errorf("subtree %v: dir %q must be a single directory name", v.Name, v.Dir)
+		}
+		if err := checkTemplates(v.templates()); err != nil {
+			return nil, fmt.Errorf("subtree %v: %v", v.Name, err)
+		}
+		replaced := false
+		for i := range ret {
+			if ret[i].Name == v.Name {
+				ret[i] = v
+				replaced = true
+			}
+		}
+		if !replaced {
+			ret = append(ret, v)
+		}
+	}
+	return ret, nil
+}
+
+// templates returns the rules of the subtree as templates of the whole table.
+func (o TSubtree) templates() []TRuleTemplate {
+	ret := []TRuleTemplate{}
+	for _, v := range o.Rules {
+		v.Profile = o.Name
+		v.Prefix = o.Dir + "/" + v.Prefix
+		ret = append(ret, v)
+	}
+	return ret
+}
+
+func isSubtreeDir(name string) bool {
+	for _, v := range subtrees {
+		if normalizeName(v.Dir) == normalizeName(name) {
+			return true
+		}
+	}
+	return false
+}
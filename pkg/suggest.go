@@ -0,0 +1,126 @@
+package rtimg
+
+import (
+	"fmt"
+	"math"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// TSuggestion - a <key> that a path without a <key> was probably meant to match.
+type TSuggestion struct {
+	Hash string
+	// the tail of the path that was compared with the <key>
+	Got   string
+	score float64
+}
+
+func (o TSuggestion) String() string {
+	return fmt.Sprintf("did you mean %v (got %v)?", o.Hash, o.Got)
+}
+
+// Suggest returns up to n <key>s of active profiles nearest to the path, the best
+// first. <key>s are ranked by edit distance, closeness of the size tag and extension.
+func Suggest(filePath string, n int) []TSuggestion {
+	p := strings.ReplaceAll(filepath.Clean(filePath), "\\", "/")
+	segments := strings.Split(p, "/")
+	list := []TSuggestion{}
+	for hash, data := range postersTable {
+		if !isProfileActive(data.Profile) {
+			continue
+		}
+		want := strings.TrimPrefix(normalizeHash(hash), "./")
+		depth := strings.Count(want, "/") + 1
+		if depth > len(segments) {
+			continue
+		}
+		got := strings.Join(segments[len(segments)-depth:], "/")
+		gotNorm := strings.TrimPrefix(normalizeHash("./"+got), "./")
+		dist := editDistance(gotNorm, want)
+		// an exact match was declined for another reason
+		if dist == 0 {
+			continue
+		}
+		score := float64(dist) + sizeDistance(path.Base(gotNorm), path.Base(want))
+		if path.Ext(gotNorm) != path.Ext(want) {
+			score++
+		}
+		if score > float64(len([]rune(want)))/4 {
+			continue
+		}
+		list = append(list, TSuggestion{Hash: hash, Got: got, score: score})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].score != list[j].score {
+			return list[i].score < list[j].score
+		}
+		return list[i].Hash < list[j].Hash
+	})
+	if len(list) > n {
+		list = list[:n]
+	}
+	return list
+}
+
+// editDistance - the number of inserted, deleted, replaced and swapped adjacent runes.
+func editDistance(a, b string) int {
+	s, t := []rune(a), []rune(b)
+	d := make([][]int, len(s)+1)
+	for i := range d {
+		d[i] = make([]int, len(t)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(s); i++ {
+		for j := 1; j <= len(t); j++ {
+			cost := 1
+			if s[i-1] == t[j-1] {
+				cost = 0
+			}
+			d[i][j] = min3(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && s[i-1] == t[j-2] && s[i-2] == t[j-1] && d[i-2][j-2]+1 < d[i][j] {
+				d[i][j] = d[i-2][j-2] + 1
+			}
+		}
+	}
+	return d[len(s)][len(t)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+// sizeDistance - the relative difference of the size tags (0..2), 1 if any of them is absent.
+func sizeDistance(a, b string) float64 {
+	w1, h1, ok1 := parseSizeTag(a)
+	w2, h2, ok2 := parseSizeTag(b)
+	if !ok1 || !ok2 {
+		return 1
+	}
+	return math.Abs(w1-w2)/math.Max(w1, w2) + math.Abs(h1-h2)/math.Max(h1, h2)
+}
+
+func parseSizeTag(name string) (float64, float64, bool) {
+	m := reSize.FindStringSubmatch(name)
+	if m == nil || m[1] == "" {
+		return 0, 0, false
+	}
+	parts := strings.Split(m[1], "x")
+	w, err1 := strconv.Atoi(parts[0])
+	h, err2 := strconv.Atoi(parts[1])
+	if err1 != nil || err2 != nil || w == 0 || h == 0 {
+		return 0, 0, false
+	}
+	return float64(w), float64(h), true
+}
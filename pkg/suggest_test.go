@@ -0,0 +1,57 @@
+package rtimg
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestSuggest -
+func TestSuggest(t *testing.T) {
+	type tCase struct {
+		path, want string
+	}
+	for _, v := range []tCase{
+		{"x/PROJECT/810x489.jpg", "./810x498.jpg"},
+		{"x/PROJECT/810x498.jgp", "./810x498.jpg"},
+		{"x/PROJECT/1920x1080_lefft.jpg", "./1920x1080_left.jpg"},
+		{"x/PROJECT/6000x600.jpg", "./600x600.jpg"},
+		{"x/PROJECT/для сервса/600x600.jpg", "./для сервиса/600x600.jpg"},
+		{"x/PROJECT/google_apple_feed/jpeg/g_hasLogo_600x600.png", "./google_apple_feed/jpg/g_hasLogo_600x600.png"},
+		{"x/PROJECT/123x456.jpg", ""},
+		{"x/PROJECT/poster.jpg", ""},
+	} {
+		list := Suggest(v.path, 1)
+		got := ""
+		if len(list) > 0 {
+			got = list[0].Hash
+		}
+		if got != v.want {
+			t.Errorf("Suggest(%q) = %v, want %q", v.path, list, v.want)
+		}
+	}
+
+	_, err := FindKey("x/PROJECT/810x489.jpg", nil)
+	if err == nil || !strings.Contains(err.Error(), "did you mean ./810x498.jpg (got 810x489.jpg)?") {
+		t.Errorf("FindKey() error: %v", err)
+	}
+}
+
+// TestEditDistance -
+func TestEditDistance(t *testing.T) {
+	type tCase struct {
+		a, b string
+		want int
+	}
+	for _, v := range []tCase{
+		{"", "", 0},
+		{"abc", "", 3},
+		{"810x498", "810x489", 1},
+		{"600x600", "6000x600", 1},
+		{"сезон", "сезно", 1},
+		{"kitten", "sitting", 3},
+	} {
+		if got := editDistance(v.a, v.b); got != v.want {
+			t.Errorf("editDistance(%q, %q) = %v, want %v", v.a, v.b, got, v.want)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package rtimg
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io/fs"
+	"os"
+)
+
+// TZone - a rectangle in fractions of the image size (0..1).
+type TZone struct {
+	Name string `json:"name"`
+	// UI overlay covers the artwork, otherwise it's a safe zone for the important content
+	Overlay bool    `json:"overlay"`
+	Left    float64 `json:"left"`
+	Top     float64 `json:"top"`
+	Right   float64 `json:"right"`
+	Bottom  float64 `json:"bottom"`
+}
+
+var (
+	overlayColor = color.NRGBA{255, 0, 0, 96}
+	safeColor    = color.NRGBA{0, 255, 0, 255}
+)
+
+// ZonesFor returns safe zones and UI overlays of the <key> rule (nil if there are none).
+// Built-in rules have none: the platforms do not publish their UI layouts, so zones
+// come only from rules of the config.
+func ZonesFor(key *TKey) []TZone {
+	if data := key.Data(); data != nil {
+		return data.Zones
+	}
+	return nil
+}
+
+// checkZones validates zones of a rule.
+func checkZones(list []TZone) error {
+	for _, v := range list {
+		if v.Name == "" {
+			return fmt.Errorf("zone: name must be set")
+		}
+		if v.Left < 0 || v.Top < 0 || v.Right > 1 || v.Bottom > 1 || v.Left >= v.Right || v.Top >= v.Bottom {
+			return fmt.Errorf("zone %v: invalid rectangle %v,%v - %v,%v", v.Name, v.Left, v.Top, v.Right, v.Bottom)
+		}
+	}
+	return nil
+}
+
+// Rect returns the zone in pixels of the bounds.
+func (o TZone) Rect(bounds image.Rectangle) image.Rectangle {
+	w, h := float64(bounds.Dx()), float64(bounds.Dy())
+	return image.Rect(
+		bounds.Min.X+int(o.Left*w), bounds.Min.Y+int(o.Top*h),
+		bounds.Min.X+int(o.Right*w), bounds.Min.Y+int(o.Bottom*h),
+	)
+}
+
+// DrawZones returns a copy of the image with UI overlays shaded and safe zones outlined.
+func DrawZones(img image.Image, zones []TZone) *image.RGBA {
+	rect := img.Bounds()
+	ret := image.NewRGBA(rect)
+	draw.Draw(ret, rect, img, rect.Min, draw.Src)
+	line := rect.Dx() / 400
+	if line < 1 {
+		line = 1
+	}
+	for _, zone := range zones {
+		r := zone.Rect(rect)
+		if zone.Overlay {
+			draw.Draw(ret, r, image.NewUniform(overlayColor), image.Point{}, draw.Over)
+			continue
+		}
+		src := image.NewUniform(safeColor)
+		draw.Draw(ret, image.Rect(r.Min.X, r.Min.Y, r.Max.X, r.Min.Y+line), src, image.Point{}, draw.Src)
+		draw.Draw(ret, image.Rect(r.Min.X, r.Max.Y-line, r.Max.X, r.Max.Y), src, image.Point{}, draw.Src)
+		draw.Draw(ret, image.Rect(r.Min.X, r.Min.Y, r.Min.X+line, r.Max.Y), src, image.Point{}, draw.Src)
+		draw.Draw(ret, image.Rect(r.Max.X-line, r.Min.Y, r.Max.X, r.Max.Y), src, image.Point{}, draw.Src)
+	}
+	return ret
+}
+
+// WritePreview writes a png copy of the image name of the file system with the zones
+// drawn over it.
+func WritePreview(fsys fs.FS, name, dstPath string, zones []TZone) error {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return err
+	}
+	img, _, err := image.Decode(f)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("decode: %v", err)
+	}
+
+	out, err := os.Create(dstPath)
+	if err != nil {
+		return err
+	}
+	err = png.Encode(out, DrawZones(img, zones))
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
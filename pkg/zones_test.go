@@ -0,0 +1,96 @@
+package rtimg
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// TestZonesFor -
+func TestZonesFor(t *testing.T) {
+	defer func(list []TRuleTemplate) {
+		ruleTemplates = list
+		rebuildTable()
+	}(ruleTemplates)
+
+	// built-in rules have no zones
+	for _, path := range []string{
+		"x/PROJECT/1920x1080.jpg",
+		"x/PROJECT/1920x1080_left.psd",
+		"x/PROJECT/350x500.jpg",
+		"x/PROJECT/для сервиса/600x840.jpg",
+		"x/PROJECT/logo.png",
+	} {
+		key, err := FindKey(path, nil)
+		if err != nil {
+			t.Errorf("FindKey(%q) error: %v", path, err)
+			continue
+		}
+		if got := ZonesFor(key); got != nil {
+			t.Errorf("ZonesFor(%q) = %v, want none", path, got)
+		}
+	}
+
+	zones := []TZone{{Name: "safe", Left: 0.1, Top: 0.1, Right: 0.9, Bottom: 0.5}}
+	config := &TConfig{Rules: []TRuleTemplate{
+		{Profile: "gp", Type: "gp", Sizes: []string{"1920x1080_left"}, Exts: map[string]int64{".jpg": 700 * kb}, Zones: zones},
+	}}
+	if err := config.Apply(); err != nil {
+		t.Fatal(err)
+	}
+	if key, err := FindKey("x/PROJECT/1920x1080_left.jpg", nil); err != nil || len(ZonesFor(key)) != 1 || ZonesFor(key)[0] != zones[0] {
+		t.Errorf("ZonesFor() = %v, %v, want zones from the config", key, err)
+	}
+	for _, zone := range []TZone{
+		{Left: 0, Top: 0, Right: 1, Bottom: 1},
+		{Name: "x", Left: 0.5, Top: 0, Right: 0.5, Bottom: 1},
+		{Name: "x", Left: 0, Top: 0, Right: 1.5, Bottom: 1},
+	} {
+		config := &TConfig{Rules: []TRuleTemplate{
+			{Profile: "gp", Type: "gp", Sizes: []string{"600x600"}, Exts: map[string]int64{".jpg": none}, Zones: []TZone{zone}},
+		}}
+		if err := config.Apply(); err == nil {
+			t.Errorf("Apply(%v) must fail", zone)
+		}
+	}
+}
+
+// TestDrawZones -
+func TestDrawZones(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 400, 200))
+	for i := range src.Pix {
+		src.Pix[i] = 255
+	}
+	zones := []TZone{
+		{Name: "menu", Overlay: true, Left: 0.5, Top: 0, Right: 1, Bottom: 1},
+		{Name: "safe", Left: 0.1, Top: 0.1, Right: 0.4, Bottom: 0.9},
+	}
+	if r := zones[1].Rect(src.Bounds()); r != image.Rect(40, 20, 160, 180) {
+		t.Errorf("Rect() = %v", r)
+	}
+	img := DrawZones(src, zones)
+
+	table := []struct {
+		x, y int
+		want color.RGBA
+	}{
+		// untouched
+		{20, 10, color.RGBA{255, 255, 255, 255}},
+		{100, 100, color.RGBA{255, 255, 255, 255}},
+		// outline of the safe zone
+		{40, 100, color.RGBA{0, 255, 0, 255}},
+		{100, 179, color.RGBA{0, 255, 0, 255}},
+	}
+	for _, v := range table {
+		if got := img.RGBAAt(v.x, v.y); got != v.want {
+			t.Errorf("(%v, %v) = %v, want %v", v.x, v.y, got, v.want)
+		}
+	}
+	// shaded overlay
+	if c := img.RGBAAt(300, 100); c.R != 255 || c.G >= 255 || c.G != c.B {
+		t.Errorf("overlay = %v", c)
+	}
+	if c := src.RGBAAt(300, 100); c.G != 255 {
+		t.Errorf("the source image was changed")
+	}
+}